@@ -0,0 +1,194 @@
+package api
+
+import (
+	"DeploymentManager/deployment"
+	"DeploymentManager/events"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// HeaderEventSource is mapped to the CloudEvent source attribute
+	HeaderEventSource = "Br-Event-Source"
+	// HeaderEventID is mapped to the CloudEvent id attribute
+	HeaderEventID = "Br-Event-ID"
+
+	defaultEventSource = "DeploymentManager/api"
+	maxRequestBodySize = 1 << 20
+)
+
+// Config is used to create the HTTP API server
+type Config struct {
+	Addr         string
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// Dispatcher receives every deployment event accepted by the API
+type Dispatcher func(ctx context.Context, event cloudevents.Event) error
+
+// ImageRequest is the body posted by the deploy-image GitHub action
+type ImageRequest struct {
+	File string `json:"file"`
+}
+
+// ImageResponse is returned once a deployment request has been accepted
+type ImageResponse struct {
+	DeploymentID string `json:"deploymentId"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type Server struct {
+	httpServer *http.Server
+	dispatch   Dispatcher
+	config     Config
+}
+
+// NewServer will return an mTLS HTTP server exposing the deployment API
+func NewServer(cfg Config, dispatch Dispatcher) (*Server, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" || cfg.ClientCAFile == "" {
+		return nil, errors.New("server certificate, key and client CA are required for mTLS")
+	}
+
+	caPem, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client CA: %w", err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPem) {
+		return nil, fmt.Errorf("no certificate found in client CA file %s", cfg.ClientCAFile)
+	}
+
+	server := &Server{
+		dispatch: dispatch,
+		config:   cfg,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/deployment/images", server.handleDeploymentImage)
+
+	server.httpServer = &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  clientCAs,
+		},
+	}
+
+	return server, nil
+}
+
+// ListenAndServe blocks until the server is shut down
+func (server *Server) ListenAndServe() error {
+	log.Println("Deployment API listening on", server.config.Addr)
+
+	err := server.httpServer.ListenAndServeTLS(server.config.CertFile, server.config.KeyFile)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// Shutdown gracefully stops the server
+func (server *Server) Shutdown(ctx context.Context) error {
+	return server.httpServer.Shutdown(ctx)
+}
+
+func (server *Server) handleDeploymentImage(w http.ResponseWriter, r *http.Request) {
+	var body ImageRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	if err := decoder.Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid request body: " + err.Error()})
+		return
+	}
+
+	request, err := decodeDeploymentFile(body.File)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	event, err := newImageCreatedEvent(r.Header, request)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	log.Printf("API accepted deployment %v for image %v from %v\n", event.ID(), request.Container.Image, event.Source())
+
+	if err := server.dispatch(r.Context(), event); err != nil {
+		log.Printf("Error dispatching deployment %v: %v\n", event.ID(), err)
+		writeJSON(w, http.StatusBadGateway, errorResponse{Error: "deployment could not be dispatched"})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, ImageResponse{DeploymentID: event.ID()})
+}
+
+// decodeDeploymentFile decodes the base64 deployment YAML sent by the GitHub action
+func decodeDeploymentFile(file string) (deployment.DeploymentRequest, error) {
+	request := deployment.DeploymentRequest{}
+
+	if file == "" {
+		return request, errors.New("file is required")
+	}
+
+	if err := events.Decode(events.ContentTypeYAMLBase64, []byte(file), &request); err != nil {
+		return request, fmt.Errorf("file is not a valid deployment: %w", err)
+	}
+
+	return request, nil
+}
+
+// newImageCreatedEvent maps the request headers to the CloudEvent attributes
+func newImageCreatedEvent(header http.Header, request deployment.DeploymentRequest) (cloudevents.Event, error) {
+	event := cloudevents.NewEvent()
+	event.SetType(events.ImageCreated)
+	event.SetTime(time.Now())
+
+	id := header.Get(HeaderEventID)
+	if id == "" {
+		id = uuid.NewString()
+	}
+	event.SetID(id)
+
+	source := header.Get(HeaderEventSource)
+	if source == "" {
+		source = defaultEventSource
+	}
+	event.SetSource(source)
+
+	if err := event.SetData(cloudevents.ApplicationJSON, request); err != nil {
+		return event, fmt.Errorf("error encoding event data: %w", err)
+	}
+
+	return event, event.Validate()
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error writing response: %v\n", err)
+	}
+}
@@ -1,41 +1,79 @@
 package cache
 
 import (
-	"encoding/json"
-	"github.com/patrickmn/go-cache"
-	"log"
-	"time"
+	"DeploymentManager/redact"
+	"github.com/infisical/go-sdk/packages/models"
+	"strings"
+	"sync"
 )
 
-type allCache struct {
-	secrets *cache.Cache
+// SecretCache is a snapshot of the secrets of every folder, keyed by path and key
+type SecretCache struct {
+	mu      sync.RWMutex
+	secrets map[string]models.Secret
 }
 
-const (
-	defaultExpiration = 9999999 * time.Minute
-	purgeTime         = 9999999 * time.Minute
-)
+func NewSecretCache() *SecretCache {
+	return &SecretCache{
+		secrets: map[string]models.Secret{},
+	}
+}
+
+// Get returns a cached secret
+func (c *SecretCache) Get(secretPath string, secretKey string) (models.Secret, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	secret, ok := c.secrets[Key(secretPath, secretKey)]
+	return secret, ok
+}
+
+// Set adds or replaces a secret of the snapshot, its value is masked in logs and events
+func (c *SecretCache) Set(secretPath string, secret models.Secret) {
+	redact.Add(secret.SecretValue)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-func NewCache() *allCache {
-	Cache := cache.New(cache.NoExpiration, 0)
-	return &allCache{
-		secrets: Cache,
+	c.secrets[Key(secretPath, secret.SecretKey)] = secret
+}
+
+// Replace swaps the whole snapshot, secrets are keyed by the folder path they were listed from.
+// Their values are masked in logs and events.
+func (c *SecretCache) Replace(secrets map[string][]models.Secret) {
+	snapshot := map[string]models.Secret{}
+	for secretPath, folderSecrets := range secrets {
+		for _, secret := range folderSecrets {
+			snapshot[Key(secretPath, secret.SecretKey)] = secret
+			redact.Add(secret.SecretValue)
+		}
 	}
+
+	c.mu.Lock()
+	c.secrets = snapshot
+	c.mu.Unlock()
 }
 
-func (c *allCache) Read(id string) (item []byte, ok bool) {
-	secret, ok := c.secrets.Get(id)
-	if ok {
-		log.Println("from cache")
-		res, err := json.Marshal(secret.(Secret))
-		if err != nil {
-			log.Fatal("Error")
+// ReplaceFolder swaps the secrets of a single folder, keys missing from secrets are removed
+func (c *SecretCache) ReplaceFolder(secretPath string, secrets []models.Secret) {
+	prefix := Key(secretPath, "")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.secrets {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.secrets, key)
 		}
-		return res, true
 	}
-	return nil, false
+	for _, secret := range secrets {
+		c.secrets[Key(secretPath, secret.SecretKey)] = secret
+		redact.Add(secret.SecretValue)
+	}
 }
 
-func (c *allCache) update(id string, product Product) {
-	c.products.Set(id, product, cache.DefaultExpiration)
+// Key identifies a secret by its path and key, the path is normalized so that /Nats and
+// /Nats/ are the same folder. The key of a folder alone is the prefix of its secrets.
+func Key(secretPath string, secretKey string) string {
+	return "/" + strings.Trim(secretPath, "/") + "\x00" + secretKey
 }
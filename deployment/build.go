@@ -0,0 +1,247 @@
+package deployment
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultDockerfile = "Dockerfile"
+
+// BuildRequest is the data of a Stack.Containers.BuildRequested event
+type BuildRequest struct {
+	// ContextDirectory is under the builds root of the manager, relative paths are relative
+	// to it
+	ContextDirectory string             `json:"contextDirectory,omitempty" yaml:"contextDirectory"`
+	Dockerfile       string             `json:"dockerfile,omitempty" yaml:"dockerfile"`
+	Image            string             `json:"image,omitempty" yaml:"image"`
+	BuildArgs        map[string]*string `json:"buildArgs,omitempty" yaml:"buildArgs"`
+	Target           string             `json:"target,omitempty" yaml:"target"`
+	Push             bool               `json:"push,omitempty" yaml:"push"`
+	// Deployment is deployed with the built image once it has been pushed
+	Deployment *DeploymentRequest `json:"deployment,omitempty" yaml:"deployment"`
+}
+
+func (docker *dockerCmd) Build(ctx context.Context, req BuildRequest) error {
+	if req.ContextDirectory == "" || req.Image == "" {
+		return errors.New("build requires a context directory and an image")
+	}
+
+	contextDirectory, err := docker.buildContextDirectory(req.ContextDirectory)
+	if err != nil {
+		return err
+	}
+
+	dockerfile := req.Dockerfile
+	if dockerfile == "" {
+		dockerfile = defaultDockerfile
+	}
+
+	excludes, err := readDockerignore(contextDirectory)
+	if err != nil {
+		return err
+	}
+
+	// The Dockerfile and .dockerignore are always sent, as the Docker CLI does
+	excludes = append(excludes, "!"+filepath.ToSlash(dockerfile), "!.dockerignore")
+
+	buildContext, err := tarBuildContext(contextDirectory, excludes)
+	if err != nil {
+		return fmt.Errorf("error creating build context: %w", err)
+	}
+	defer buildContext.Close()
+
+	log.Printf("Building image %v from %v\n", req.Image, contextDirectory)
+
+	resp, err := docker.cli.ImageBuild(ctx, buildContext, types.ImageBuildOptions{
+		Tags:        []string{req.Image},
+		Dockerfile:  dockerfile,
+		BuildArgs:   req.BuildArgs,
+		Target:      req.Target,
+		NoCache:     docker.noCache,
+		Remove:      true,
+		ForceRemove: docker.forceRm,
+		PullParent:  docker.pull,
+		AuthConfigs: docker.registryAuthMap,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err = streamBuildOutput(resp.Body); err != nil {
+		return fmt.Errorf("error building image %v: %w", req.Image, err)
+	}
+
+	log.Println("Image build completed successfully.")
+
+	if req.Push {
+		log.Println("Pushing image: ", req.Image)
+		if err = docker.Push(ctx, req.Image); err != nil {
+			return fmt.Errorf("error pushing image %v: %w", req.Image, err)
+		}
+		log.Println("Image push completed successfully.")
+	}
+
+	return nil
+}
+
+// buildContextDirectory resolves the context directory of a build, which must be under the
+// builds root once its symbolic links are followed
+func (docker *dockerCmd) buildContextDirectory(contextDirectory string) (string, error) {
+	if docker.buildsRoot == "" {
+		return "", errors.New("no builds root is configured, set BUILDS_ROOT to build images")
+	}
+
+	root, err := filepath.EvalSymlinks(docker.buildsRoot)
+	if err != nil {
+		return "", fmt.Errorf("invalid builds root: %w", err)
+	}
+
+	if !filepath.IsAbs(contextDirectory) {
+		contextDirectory = filepath.Join(root, contextDirectory)
+	}
+	resolved, err := filepath.EvalSymlinks(contextDirectory)
+	if err != nil {
+		return "", fmt.Errorf("invalid context directory: %w", err)
+	}
+
+	relative, err := filepath.Rel(root, resolved)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("context directory %v is outside the builds root %v", contextDirectory, docker.buildsRoot)
+	}
+
+	return resolved, nil
+}
+
+// readDockerignore returns the patterns of the .dockerignore of a context directory
+func readDockerignore(contextDirectory string) ([]string, error) {
+	file, err := os.Open(filepath.Join(contextDirectory, ".dockerignore"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ignorefile.ReadAll(file)
+}
+
+// tarBuildContext streams a tar of the context directory, leaving out the excluded paths
+func tarBuildContext(contextDirectory string, excludes []string) (io.ReadCloser, error) {
+	matcher, err := patternmatcher.New(excludes)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+
+	go func() {
+		tarWriter := tar.NewWriter(writer)
+
+		err := filepath.Walk(contextDirectory, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			relPath, err := filepath.Rel(contextDirectory, path)
+			if err != nil || relPath == "." {
+				return err
+			}
+
+			excluded, err := matcher.MatchesOrParentMatches(relPath)
+			if err != nil {
+				return err
+			}
+			if excluded {
+				// Exceptions may still include files below an excluded directory
+				if info.IsDir() && !matcher.Exclusions() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			return addToTar(tarWriter, path, filepath.ToSlash(relPath), info)
+		})
+
+		if err == nil {
+			err = tarWriter.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	return reader, nil
+}
+
+func addToTar(tarWriter *tar.Writer, path string, name string, info os.FileInfo) error {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(tarWriter, file)
+	return err
+}
+
+// streamBuildOutput logs the build output and returns the first error it reports,
+// in the same way as detectErrorMessage
+func streamBuildOutput(in io.Reader) error {
+	dec := json.NewDecoder(in)
+
+	for {
+		var jm jsonmessage.JSONMessage
+		if err := dec.Decode(&jm); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		if jm.Error != nil {
+			return jm.Error
+		}
+
+		if line := strings.TrimSpace(jm.Stream); line != "" {
+			log.Println("[build]", line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"log"
+	"sync"
+	"time"
+)
+
+// Concurrency policies of a cron job whose previous run is still active
+const (
+	ConcurrencyAllow   = "Allow"
+	ConcurrencyForbid  = "Forbid"
+	ConcurrencyReplace = "Replace"
+)
+
+const defaultHistoryLimit = 10
+
+// JobHandler is called with the outcome of every scheduled run
+type JobHandler func(req DeploymentRequest, result *JobResult, err error)
+
+// cronScheduler runs the cron jobs and tracks their active runs
+type cronScheduler struct {
+	cron    *cron.Cron
+	ctx     context.Context
+	onRun   JobHandler
+	mu      sync.Mutex
+	entries map[string]cron.EntryID
+	runs    map[string]map[uint64]context.CancelFunc
+	nextRun uint64
+}
+
+func newCronScheduler() *cronScheduler {
+	return &cronScheduler{
+		cron:    cron.New(),
+		ctx:     context.Background(),
+		onRun:   func(DeploymentRequest, *JobResult, error) {},
+		entries: map[string]cron.EntryID{},
+		runs:    map[string]map[uint64]context.CancelFunc{},
+	}
+}
+
+func isCronJob(req DeploymentRequest) bool {
+	return req.Kind == KindCronJob
+}
+
+// cronSchedule parses the schedule of a cron job and checks its concurrency policy
+func cronSchedule(req DeploymentRequest) (cron.Schedule, error) {
+	if !isCronJob(req) {
+		return nil, fmt.Errorf("%v is a %v, not a %v", deploymentName(req), req.Kind, KindCronJob)
+	}
+
+	switch req.Spec.ConcurrencyPolicy {
+	case "", ConcurrencyAllow, ConcurrencyForbid, ConcurrencyReplace:
+	default:
+		return nil, fmt.Errorf("unknown concurrencyPolicy %q", req.Spec.ConcurrencyPolicy)
+	}
+
+	schedule, err := cron.ParseStandard(req.Spec.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", req.Spec.Schedule, err)
+	}
+
+	return schedule, nil
+}
+
+func historyLimit(req DeploymentRequest) int {
+	if req.Spec.HistoryLimit == nil {
+		return defaultHistoryLimit
+	}
+	return *req.Spec.HistoryLimit
+}
+
+// cronJobRun returns the job run on each schedule of a cron job, without the fields only
+// a cron job has
+func cronJobRun(req DeploymentRequest) DeploymentRequest {
+	job := req
+	job.Kind = KindJob
+	job.Spec.Schedule = ""
+	job.Spec.ConcurrencyPolicy = ""
+	job.Spec.HistoryLimit = nil
+	return job
+}
+
+// ScheduleCronJob stores a cron job and schedules its runs, replacing its previous schedule
+func (docker *dockerCmd) ScheduleCronJob(req DeploymentRequest) error {
+	schedule, err := cronSchedule(req)
+	if err != nil {
+		return err
+	}
+	if err := docker.Validate(req); err != nil {
+		return err
+	}
+
+	state, err := docker.store.CronJob(deploymentName(req))
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &CronJobState{}
+	}
+	state.Request = req
+
+	if err := docker.store.SaveCronJob(*state); err != nil {
+		return err
+	}
+
+	docker.scheduleCronJob(deploymentName(req), schedule)
+	log.Printf("Cron job %v scheduled: %v\n", deploymentName(req), req.Spec.Schedule)
+
+	return nil
+}
+
+// StartCronJobs schedules the stored cron jobs and starts running them until ctx is done.
+// Schedules missed while the manager was stopped are not run.
+func (docker *dockerCmd) StartCronJobs(ctx context.Context, onRun JobHandler) error {
+	scheduler := docker.cronJobs
+	scheduler.mu.Lock()
+	scheduler.ctx = ctx
+	scheduler.onRun = onRun
+	scheduler.mu.Unlock()
+
+	states, err := docker.store.CronJobs()
+	if err != nil {
+		return err
+	}
+
+	for _, state := range states {
+		name := deploymentName(state.Request)
+		schedule, err := cronSchedule(state.Request)
+		if err != nil {
+			log.Printf("Error scheduling cron job %v: %v\n", name, err)
+			continue
+		}
+
+		if !state.LastScheduleTime.IsZero() && schedule.Next(state.LastScheduleTime).Before(time.Now()) {
+			log.Printf("Skipping the runs of cron job %v missed since %v\n", name, state.LastScheduleTime)
+		}
+
+		docker.scheduleCronJob(name, schedule)
+	}
+
+	scheduler.cron.Start()
+	go func() {
+		<-ctx.Done()
+		scheduler.cron.Stop()
+	}()
+
+	return nil
+}
+
+func (docker *dockerCmd) scheduleCronJob(name string, schedule cron.Schedule) {
+	scheduler := docker.cronJobs
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	if id, ok := scheduler.entries[name]; ok {
+		scheduler.cron.Remove(id)
+	}
+
+	scheduler.entries[name] = scheduler.cron.Schedule(schedule, cron.FuncJob(func() {
+		docker.runCronJob(name, schedule)
+	}))
+}
+
+// runCronJob runs a cron job once per schedule, even if it fires again for a schedule
+// already run, and applies its concurrency policy
+func (docker *dockerCmd) runCronJob(name string, schedule cron.Schedule) {
+	scheduler := docker.cronJobs
+	now := time.Now()
+
+	scheduler.mu.Lock()
+
+	state, err := docker.store.CronJob(name)
+	if err != nil || state == nil {
+		scheduler.mu.Unlock()
+		log.Printf("Error reading cron job %v: %v\n", name, err)
+		return
+	}
+
+	if !state.LastScheduleTime.IsZero() && schedule.Next(state.LastScheduleTime).After(now) {
+		scheduler.mu.Unlock()
+		log.Printf("Cron job %v already ran at %v\n", name, state.LastScheduleTime)
+		return
+	}
+
+	// The schedule is recorded before the run so that a restart cannot run it again
+	state.LastScheduleTime = now
+	if err := docker.store.SaveCronJob(*state); err != nil {
+		scheduler.mu.Unlock()
+		log.Printf("Error saving cron job %v: %v\n", name, err)
+		return
+	}
+
+	active := scheduler.runs[name]
+	switch state.Request.Spec.ConcurrencyPolicy {
+	case ConcurrencyForbid:
+		if len(active) > 0 {
+			scheduler.mu.Unlock()
+			log.Printf("Cron job %v is still running, skipping this run\n", name)
+			return
+		}
+	case ConcurrencyReplace:
+		for _, cancel := range active {
+			log.Printf("Cron job %v is still running, replacing it\n", name)
+			cancel()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(scheduler.ctx)
+	defer cancel()
+
+	scheduler.nextRun++
+	runId := scheduler.nextRun
+	if active == nil {
+		active = map[uint64]context.CancelFunc{}
+		scheduler.runs[name] = active
+	}
+	active[runId] = cancel
+	onRun := scheduler.onRun
+
+	scheduler.mu.Unlock()
+
+	job := cronJobRun(state.Request)
+	policy := state.Request.Spec.ConcurrencyPolicy
+	result, err := docker.startJob(ctx, job, "", policy == "" || policy == ConcurrencyAllow)
+
+	scheduler.mu.Lock()
+	delete(active, runId)
+	scheduler.mu.Unlock()
+
+	if err := docker.store.PruneRevisions(name, historyLimit(state.Request)); err != nil {
+		log.Printf("Error pruning the history of cron job %v: %v\n", name, err)
+	}
+
+	onRun(job, result, err)
+}
@@ -13,10 +13,10 @@ import (
 	"github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/jsonmessage"
-	"github.com/docker/go-connections/nat"
 	"io"
 	"log"
-	"os"
+	"sync"
+	"time"
 )
 
 // ImageSummary of a deployment image
@@ -43,6 +43,23 @@ type dockerCmd struct {
 	noCache            bool
 	forceRm            bool
 	pull               bool
+	store              *Store
+	locks              sync.Map
+	// network is the network of the containers whose request lists none
+	network  string
+	security SecurityDefaults
+	cronJobs *cronScheduler
+	secrets  SecretResolver
+	// secretFilesDir is the host directory, on a tmpfs, holding the secret files
+	secretFilesDir string
+	// fileSecretsErr is why secrets cannot be delivered as files, nil if they can
+	fileSecretsErr error
+	// rotationConcurrency is the number of deployments rolled at once on secret rotation
+	rotationConcurrency int
+	// bindMountRoots are the host paths under which requests may bind mount
+	bindMountRoots []string
+	// buildsRoot is the directory of the manager holding the build contexts
+	buildsRoot string
 }
 
 // Configs are used to create the deployment client
@@ -51,25 +68,53 @@ type Configs struct {
 	Registry string
 	Username string
 	Password string
+	// StatePath is the file of the deployment state store
+	StatePath string
+	// Network is the default network of the deployed containers, bluerobin if empty
+	Network string
+	// Security holds the security options of the containers whose request does not set them
+	Security SecurityDefaults
+	// Secrets resolves the secrets of the requests
+	Secrets SecretResolver
+	// SecretFilesDir is the host directory, on a tmpfs, holding the secrets delivered as
+	// files, /run/bluerobin/secrets if empty
+	SecretFilesDir string
+	// RotationConcurrency is the number of deployments rolled at once when a secret
+	// changes, 4 if not positive
+	RotationConcurrency int
+	// BindMountRoots are the host paths under which requests may bind mount, none when
+	// empty unless privileged containers are allowed
+	BindMountRoots []string
+	// BuildsRoot is the directory of the manager holding the build contexts, no image is
+	// built when empty
+	BuildsRoot string
 }
 
 // Docker is an interface that contains some operations which can be used to build an image from source code
 type Docker interface {
-	Build(ctx context.Context, contextDirectory, imagePath string, args map[string]*string) error
+	Build(ctx context.Context, req BuildRequest) error
 	Pull(ctx context.Context, imagePath string) error
 	Push(ctx context.Context, imagePath string) error
 	List(ctx context.Context, filters map[string]string) ([]*ImageSummary, error)
 	Tag(ctx context.Context, imagePath, newImagePath string) error
 	Rmi(ctx context.Context, imagePath string) error
 	RegistryLogin(ctx context.Context) error
-	DeployContainer(ctx context.Context, deploymentRequest DeploymentRequest) (string, error)
-	RecreateRunningContainers(ctx context.Context) error
+	DeployContainer(ctx context.Context, deploymentRequest DeploymentRequest, eventID string) (*DeploymentResult, error)
+	RecreateRunningContainers(ctx context.Context, secretPath string, secretKey string, eventID string) ([]RotationResult, error)
+	RunJob(ctx context.Context, req DeploymentRequest, eventID string) (*JobResult, error)
+	ScheduleCronJob(req DeploymentRequest) error
+	Validate(req DeploymentRequest) error
+	StartCronJobs(ctx context.Context, onRun JobHandler) error
+	Revisions(name string) ([]Revision, error)
+	CurrentRevision(name string) (*Revision, error)
+	RebuildState(ctx context.Context) error
+	Reconcile(ctx context.Context, repair bool) ([]Drift, error)
 }
 
 func (docker *dockerCmd) RegistryLogin(ctx context.Context) error {
 	out, err := docker.cli.RegistryLogin(ctx, docker.registryAuthConfig)
 	if err != nil {
-		log.Panicln("Error logging into Docker registry: %v\n", err)
+		log.Panicf("Error logging into Docker registry: %v\n", err)
 	} else {
 		log.Println("Logged into Docker registry: ", out.Status)
 	}
@@ -77,106 +122,179 @@ func (docker *dockerCmd) RegistryLogin(ctx context.Context) error {
 	return err
 }
 
-func (docker *dockerCmd) DeployContainer(ctx context.Context, req DeploymentRequest) (string, error) {
+// DeployContainer deploys a request and records it as a new revision of its deployment
+func (docker *dockerCmd) DeployContainer(ctx context.Context, req DeploymentRequest, eventID string) (*DeploymentResult, error) {
+	if isJob(req) || isCronJob(req) {
+		return nil, fmt.Errorf("%v is a %v, it must be run rather than deployed", deploymentName(req), req.Kind)
+	}
+	if err := docker.Validate(req); err != nil {
+		return nil, err
+	}
+
+	revision := &Revision{
+		Request:   req,
+		EventID:   eventID,
+		StartedAt: time.Now(),
+	}
+
+	// One deployment of a given name at a time
+	lock := docker.deploymentLock(deploymentName(req))
+	lock.Lock()
+	defer lock.Unlock()
+
+	number, err := docker.store.NextRevisionNumber(deploymentName(req))
+	if err != nil {
+		return nil, err
+	}
+	revision.Number = number
+
+	result, err := docker.deploy(ctx, revision)
+
+	revision.FinishedAt = time.Now()
+	docker.recordRevision(revision, result, err)
+
+	return result, err
+}
+
+// deploymentLock returns the lock held while a deployment is changed
+func (docker *dockerCmd) deploymentLock(name string) *sync.Mutex {
+	lock, _ := docker.locks.LoadOrStore(name, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+func (docker *dockerCmd) deploy(ctx context.Context, revision *Revision) (*DeploymentResult, error) {
+	req := revision.Request
 
 	// Cleanup: Remove exited containers
 	go removeExitedContainers(ctx, docker.cli)
 
 	// Pull image
 	imageName := req.Container.Image
-	containerName := req.Container.Name
+
+	// Keep the running revision to roll back to
+	previous := docker.previousRevision(ctx, req)
 
 	log.Println("Pulling image: ", imageName)
 	err := docker.Pull(ctx, imageName)
 
 	if err != nil {
 		log.Printf("Error pulling from Docker registry: %v\n", err)
-		return "", err
+		return nil, err
 	}
 
-	// Stop and remove containers using the same image
-	log.Println("Stopping running container using image: ", imageName)
-	err = docker.stopRunningContainersByImage(ctx, imageName, containerName)
+	revision.ImageDigest, err = docker.imageDigest(ctx, imageName)
 	if err != nil {
-		log.Printf("Error stopping containers: %v\n", err)
+		log.Printf("Error inspecting image: %v\n", err)
+		return nil, err
 	}
 
-	// Cleanup: Remove dangling images
-	go removeDanglingImages(ctx, docker.cli)
-
 	log.Println("Creating container...")
-	// If there are environment variables, add them
-	var envVars []string
-	if req.Container.EnvVars != nil {
-		for _, envVar := range req.Container.EnvVars {
-			envVars = append(envVars, envVar.Name+"="+envVar.Value)
-		}
+	template, err := docker.newReplicaTemplate(*revision, false)
+	if err != nil {
+		log.Printf("Error resolving the environment of %v: %v\n", deploymentName(req), err)
+		return nil, err
 	}
 
-	// If secrets, add them. They are loaded from environment
-	if req.Container.Secrets != nil {
-		if req.Container.Secrets != nil {
-			for _, secret := range req.Container.Secrets {
-				envVars = append(envVars, secret.SecretKey+"="+os.Getenv(secret.SecretKey))
-			}
-		}
+	log.Println("Environment variables: ", redactedEnv(req, template.envVars))
+
+	// Replace the replicas one batch at a time, old containers are only retired
+	// once their replacement is running
+	result, err := docker.rollout(ctx, template)
+	if err != nil {
+		log.Printf("Error rolling out %v: %v\n", deploymentName(req), err)
+		revision.ContainerIDs = result.ContainerIDs
+		return docker.rollback(ctx, revision, previous, result, err)
 	}
+	result.ImageDigest = revision.ImageDigest
 
-	log.Println("Environment variables: ", envVars)
+	// Remove the replicas left over by a previous deployment with more replicas
+	err = docker.removeExtraReplicas(ctx, req)
+	if err != nil {
+		log.Printf("Error scaling down replicas: %v\n", err)
+	}
 
-	// Initialise portBinding as nil
-	containerPortBinding := nat.PortMap{}
-	exposedPort := nat.PortSet{}
+	// Cleanup: Remove dangling images
+	go removeDanglingImages(ctx, docker.cli)
 
-	if req.Container.ContainerPort != "" {
-		exposedPort = map[nat.Port]struct{}{
-			nat.Port(req.Container.ContainerPort + "/tcp"): {},
-		}
+	return result, nil
+}
 
-		// Set port binding
-		hostBinding := nat.PortBinding{
-			HostIP:   req.Container.Binding,
-			HostPort: req.Container.HostPort,
-		}
+// createReplica creates and starts the container of a single replica under the given name.
+// The container ID is returned even if it failed to start so that it can be removed.
+func (docker *dockerCmd) createReplica(ctx context.Context, template replicaTemplate, index int, containerName string) (string, error) {
+	req := template.req
 
-		containerPortBinding = nat.PortMap{
-			nat.Port(req.Container.ContainerPort + "/tcp"): []nat.PortBinding{hostBinding},
-		}
+	exposedPort, containerPortBinding, err := replicaPorts(req, index)
+	if err != nil {
+		return "", err
+	}
+
+	mounts, err := replicaMounts(req)
+	if err != nil {
+		return "", err
+	}
+	// Stored revisions are checked again, the allowed host paths may have changed
+	if err := docker.checkBindMounts(req); err != nil {
+		return "", err
+	}
+	secretMounts, err := docker.secretMounts(req)
+	if err != nil {
+		return "", err
+	}
+	mounts = append(mounts, secretMounts...)
+
+	resources, err := replicaResources(req)
+	if err != nil {
+		return "", err
+	}
 
+	restart, err := restartPolicy(req)
+	if err != nil {
+		return "", err
 	}
+
 	// Create container config
 	containerConfig := &containertypes.Config{
-		Image:        imageName,
-		Env:          envVars,
+		Image:        req.Container.Image,
+		Env:          template.envVars,
 		ExposedPorts: exposedPort,
+		Labels:       template.replicaLabels(index),
+		Healthcheck:  healthConfig(req.Container.LivenessProbe),
+		Entrypoint:   req.Container.Entrypoint,
+		Cmd:          req.Container.Command,
+		User:         req.Container.User,
+		WorkingDir:   req.Container.WorkingDir,
+		Hostname:     req.Container.Hostname,
+		StopSignal:   req.Container.StopSignal,
+		StopTimeout:  req.Container.StopGracePeriodSeconds,
 	}
 
 	// Create host config
 	hostConfig := &containertypes.HostConfig{
-		PortBindings: containerPortBinding,
-		RestartPolicy: containertypes.RestartPolicy{
-			Name: containertypes.RestartPolicyAlways,
-		},
+		PortBindings:  containerPortBinding,
+		Mounts:        mounts,
+		Resources:     resources,
+		RestartPolicy: restart,
+		DNS:           req.Container.DNS,
+		DNSSearch:     req.Container.DNSSearch,
+		ExtraHosts:    req.Container.ExtraHosts,
+	}
+	if err := docker.securityConfig(req, hostConfig); err != nil {
+		return "", err
 	}
 
-	// Create network config for existing network call "bluerobin"
+	// The container is created on the first network and connected to the others before
+	// it starts, older daemons only accept a single network on creation
+	networks, err := replicaNetworks(req, docker.network)
+	if err != nil {
+		return "", err
+	}
 	networkConfig := &network.NetworkingConfig{
 		EndpointsConfig: map[string]*network.EndpointSettings{
-			"bluerobin": {},
+			networks[0].Name: endpointSettings(networks[0]),
 		},
 	}
 
-	// Check if network exists
-	filtersNetwork := filters.NewArgs()
-	filtersNetwork.Add("name", "bluerobin")
-
-	listNetwork, _ := docker.cli.NetworkList(ctx, network.ListOptions{Filters: filtersNetwork})
-
-	if len(listNetwork) == 0 {
-		log.Println("Creating network: bluerobin")
-		docker.cli.NetworkCreate(ctx, "bluerobin", network.CreateOptions{})
-	}
-
 	// Create new container
 	resp, err := docker.cli.ContainerCreate(ctx, containerConfig, hostConfig, networkConfig, nil, containerName)
 	if err != nil {
@@ -184,115 +302,232 @@ func (docker *dockerCmd) DeployContainer(ctx context.Context, req DeploymentRequ
 		return "", err
 	}
 
+	for _, net := range networks[1:] {
+		if err := docker.cli.NetworkConnect(ctx, net.Name, resp.ID, endpointSettings(net)); err != nil {
+			log.Printf("Error connecting container to network %v: %v\n", net.Name, err)
+			return resp.ID, err
+		}
+	}
+
+	// The secret files are in place before the process starts
+	if err := docker.writeSecretFiles(ctx, resp.ID, template.files); err != nil {
+		log.Printf("Error writing secret files: %v\n", err)
+		return resp.ID, err
+	}
+
 	// Start the container
 	if err := docker.cli.ContainerStart(ctx, resp.ID, containertypes.StartOptions{}); err != nil {
 		log.Println("Error starting container: ", err)
-		return "", err
+		return resp.ID, err
 	}
 
-	return resp.ID, err
+	return resp.ID, nil
 }
 
-func (docker *dockerCmd) RecreateRunningContainers(ctx context.Context) error {
-	// Stop and remove containers using the same image
-	filterArgs := filters.NewArgs()
-	filterArgs.Add("network", "bluerobin")
-	containers, _ := docker.cli.ContainerList(ctx, containertypes.ListOptions{All: true, Filters: filterArgs})
+// RecreateRunningContainers deploys again the current revision of the deployments referencing
+// a changed secret, secretKey empty for every secret of the folder secretPath, both empty for
+// every secret. Deployments are rolled in parallel and the outcome of each one is returned.
+func (docker *dockerCmd) RecreateRunningContainers(ctx context.Context, secretPath string, secretKey string, eventID string) ([]RotationResult, error) {
+	revisions, err := docker.managedRevisions(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	// Loop over each container, copy its configuration and recreate it
-	for _, container := range containers {
+	legacy, err := docker.legacyRevisions(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		// Get the container configuration
-		fileName := container.ID + ".gob"
-		// Load the request object from directory /deployments
-		var request DeploymentRequest
-		err := utils.ReadFromFile(fileName, &request)
-		if err != nil {
-			fmt.Println("Error reading object:", err)
-			return err
+	affected := newSecretIndex(append(revisions, legacy...)).affected(secretPath, secretKey)
+	log.Printf("Secret change (path %q, key %q) affects %v deployments\n", secretPath, secretKey, len(affected))
+
+	results := make([]RotationResult, len(affected))
+	limit := make(chan struct{}, docker.rotationConcurrency)
+
+	var wg sync.WaitGroup
+	for i, revision := range affected {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			results[i] = docker.rotate(ctx, revision, eventID)
+		}()
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// rotate reloads the secrets of a revision according to its secret reload strategy
+func (docker *dockerCmd) rotate(ctx context.Context, revision Revision, eventID string) RotationResult {
+	name := deploymentName(revision.Request)
+	strategy := secretReloadStrategy(revision.Request)
+	result := RotationResult{Revision: revision, Strategy: strategy}
+
+	switch strategy {
+	case SecretReloadNone:
+		log.Printf("Secrets of %v changed, its containers keep the old ones\n", name)
+		return result
+
+	// Secrets delivered as files are updated in place
+	case SecretReloadFiles, SecretReloadSignal:
+		signal := ""
+		if strategy == SecretReloadSignal {
+			signal = reloadSignal(revision.Request)
 		}
 
-		containerId, err := docker.DeployContainer(ctx, request)
-		if err != nil {
-			log.Printf("Error deploying container: %v\n", err)
-			return err
+		result.Err = docker.updateSecretFiles(ctx, name, signal)
+		if result.Err != nil {
+			log.Printf("Error updating the secret files of %v: %v\n", name, result.Err)
 		}
+		return result
+	}
 
-		// Save the request object to directory /deployments
-		fileName = containerId + ".gob"
-		err = utils.SaveToFile(fileName, request)
-		if err != nil {
-			fmt.Println("Error saving object:", err)
-			return err
+	result.Result, result.Err = docker.DeployContainer(ctx, revision.Request, eventID)
+	if result.Err != nil {
+		log.Printf("Error deploying %v: %v\n", name, result.Err)
+		return result
+	}
+
+	// Legacy records are superseded by the revision in the store
+	if revision.Number == 0 {
+		for _, containerId := range revision.ContainerIDs {
+			if err := utils.DeleteFile(containerId + ".gob"); err != nil {
+				fmt.Println("Error deleting file:", err)
+			}
+		}
+	}
+
+	return result
+}
+
+// managedRevisions returns the current revision of every deployment found on the labels of
+// the managed containers
+func (docker *dockerCmd) managedRevisions(ctx context.Context) ([]Revision, error) {
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("label", LabelManagedBy+"="+managedBy)
+	containers, err := docker.cli.ContainerList(ctx, containertypes.ListOptions{All: true, Filters: filterArgs})
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []Revision
+	seen := map[string]bool{}
+	for _, container := range containers {
+		name := container.Labels[LabelDeployment]
+		if name == "" || seen[name] {
+			continue
 		}
+		seen[name] = true
 
-		// Delete old file container.ID + ".gob"
-		err = utils.DeleteFile(container.ID + ".gob")
+		current, err := docker.store.Current(name)
 		if err != nil {
-			fmt.Println("Error deleting file:", err)
-			return err
+			return nil, err
+		}
+		if current == nil {
+			log.Printf("No current revision of %v, its containers are not recreated\n", name)
+			continue
+		}
+		// A running job keeps its environment until it exits
+		if isJob(current.Request) {
+			continue
 		}
+		revisions = append(revisions, *current)
 	}
 
-	return nil
+	return revisions, nil
 }
 
-func (docker *dockerCmd) stopRunningContainersByImage(ctx context.Context, imageName string, containerName string) error {
-	// Check if a container with the same name already exists and stop it
+// legacyRevisions reads the requests saved as <container ID>.gob files for the containers
+// deployed before they were labelled
+func (docker *dockerCmd) legacyRevisions(ctx context.Context) ([]Revision, error) {
+	containers, err := docker.cli.ContainerList(ctx, containertypes.ListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
 
-	filtersArgsImage := filters.NewArgs()
-	filtersArgsImage.Add("ancestor", imageName)
+	names := map[string]int{}
+	var legacy []Revision
+	for _, container := range containers {
+		if _, ok := container.Labels[LabelManagedBy]; ok {
+			continue
+		}
 
-	filtersArgsName := filters.NewArgs()
-	filtersArgsName.Add("name", containerName)
+		// Load the request object from directory /data, unmanaged containers have none
+		var request DeploymentRequest
+		if err := utils.ReadFromFile(container.ID+".gob", &request); err != nil {
+			continue
+		}
+		forgetSecretValues(&request)
 
-	listFilters := []filters.Args{filtersArgsImage, filtersArgsName}
+		// Replicas share the same request, only redeploy each deployment once
+		if index, ok := names[deploymentName(request)]; ok {
+			legacy[index].ContainerIDs = append(legacy[index].ContainerIDs, container.ID)
+			continue
+		}
 
-	log.Printf("Checking for running containers using image '%v' or with name '%v'", imageName, containerName)
+		names[deploymentName(request)] = len(legacy)
+		legacy = append(legacy, Revision{Request: request, ContainerIDs: []string{container.ID}})
+	}
 
-	for _, filter := range listFilters {
+	return legacy, nil
+}
 
-		containers, err := docker.cli.ContainerList(ctx, containertypes.ListOptions{All: true, Filters: filter})
-		if err != nil {
-			panic(err)
-		}
+// Revisions returns the revision history of a deployment
+func (docker *dockerCmd) Revisions(name string) ([]Revision, error) {
+	return docker.store.Revisions(name)
+}
 
-		log.Printf("--> Found %v running containers", len(containers))
+// CurrentRevision returns the revision deployed for a deployment, nil if none
+func (docker *dockerCmd) CurrentRevision(name string) (*Revision, error) {
+	return docker.store.Current(name)
+}
 
-		for _, icontainer := range containers {
+// recordRevision stores the outcome of a deployment, and the restored revision after a rollback
+func (docker *dockerCmd) recordRevision(revision *Revision, result *DeploymentResult, err error) {
+	switch {
+	case result != nil && result.RolledBack:
+		revision.Outcome = OutcomeRolledBack
+	case err != nil:
+		revision.Outcome = OutcomeFailed
+	default:
+		revision.Outcome = OutcomeSucceeded
+		revision.ContainerIDs = result.ContainerIDs
+	}
 
-			if icontainer.Image == imageName || icontainer.Names[0] == "/"+containerName {
+	if err != nil {
+		revision.Error = err.Error()
+	}
 
-				noWaitTimeout := 0 // to not wait for the container to exit gracefully
-				err := docker.cli.ContainerStop(
-					ctx,
-					icontainer.ID,
-					containertypes.StopOptions{Timeout: &noWaitTimeout},
-				)
+	if storeErr := docker.store.AddRevision(revision); storeErr != nil {
+		log.Printf("Error storing revision of %v: %v\n", deploymentName(revision.Request), storeErr)
+		return
+	}
 
-				if err != nil {
-					panic(err)
-				}
+	if result == nil {
+		return
+	}
+	result.Revision = revision.Number
 
-				// Remove the container
-				err = docker.cli.ContainerRemove(
-					ctx,
-					icontainer.ID,
-					containertypes.RemoveOptions{Force: true},
-				)
+	if result.RolledBack {
+		restored := *result.Previous
+		restored.Number = revision.Number + 1
+		restored.ContainerIDs = result.ContainerIDs
+		restored.EventID = revision.EventID
+		restored.StartedAt = revision.StartedAt
+		restored.FinishedAt = revision.FinishedAt
+		restored.Outcome = OutcomeRestored
+		restored.Error = ""
 
-				if err != nil {
-					log.Printf("Error removing container: %v\n", err)
-				}
-			}
+		if storeErr := docker.store.AddRevision(&restored); storeErr != nil {
+			log.Printf("Error storing restored revision of %v: %v\n", deploymentName(restored.Request), storeErr)
+			return
 		}
+		result.Revision = restored.Number
 	}
-	return nil
-}
-
-func (docker *dockerCmd) Build(ctx context.Context, contextDirectory, imagePath string, args map[string]*string) error {
-	//TODO implement me
-	panic("implement me")
 }
 
 // NewClient will return a deployment image builder client
@@ -324,9 +559,41 @@ func NewClient(cfg Configs) (Docker, error) {
 		registryAuthMap: map[string]registry.AuthConfig{
 			cfg.Registry: auth,
 		},
-		noCache: true,
-		forceRm: true,
-		pull:    true,
+		noCache:             true,
+		forceRm:             true,
+		pull:                true,
+		network:             cfg.Network,
+		security:            cfg.Security,
+		cronJobs:            newCronScheduler(),
+		secrets:             cfg.Secrets,
+		secretFilesDir:      cfg.SecretFilesDir,
+		rotationConcurrency: cfg.RotationConcurrency,
+		bindMountRoots:      cfg.BindMountRoots,
+		buildsRoot:          cfg.BuildsRoot,
+	}
+	if docker.network == "" {
+		docker.network = defaultNetwork
+	}
+	if docker.secretFilesDir == "" {
+		docker.secretFilesDir = defaultSecretFilesDir
+	}
+	// Secret files are never written to a disk
+	if err := checkTmpfs(docker.secretFilesDir); err != nil {
+		docker.fileSecretsErr = fmt.Errorf("secrets cannot be delivered as files, %v must be a tmpfs of the host mounted at the same path in the manager: %w", docker.secretFilesDir, err)
+		log.Println(docker.fileSecretsErr)
+	}
+	if docker.rotationConcurrency < 1 {
+		docker.rotationConcurrency = defaultRotationConcurrency
+	}
+
+	docker.store, err = OpenStore(cfg.StatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Jobs running when the manager stopped are not waited for anymore
+	if err := docker.store.FailRunning("interrupted by a restart of the manager"); err != nil {
+		return nil, err
 	}
 
 	return docker, nil
@@ -472,6 +739,11 @@ func removeExitedContainers(ctx context.Context, cli *client.Client) {
 	}
 
 	for _, container := range containers {
+		// Managed replicas are retired by their rollout, a stopped one may be kept for recovery
+		if _, managed := container.Labels[LabelDeployment]; managed {
+			continue
+		}
+
 		if container.State == "exited" {
 			fmt.Printf("Removing container %s\n", container.ID)
 			if err := cli.ContainerRemove(ctx, container.ID, containertypes.RemoveOptions{Force: true}); err != nil {
@@ -0,0 +1,220 @@
+package deployment
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"io"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	jobPollInterval = time.Second
+	// maxJobLogs is the size of the end of the logs kept for a job
+	maxJobLogs = 64 * 1024
+)
+
+// JobResult is the outcome of a job run
+type JobResult struct {
+	ContainerID string
+	ImageDigest string
+	Revision    int
+	ExitCode    int
+	// Attempts counts the first run and every restart
+	Attempts int
+	Logs     string
+	Duration time.Duration
+}
+
+func isJob(req DeploymentRequest) bool {
+	return req.Kind == KindJob
+}
+
+// RunJob runs the container of a job request to completion and records its exit code and
+// logs as a new revision. One run of a given job at a time. An error is returned if the job
+// could not run or did not exit with 0.
+func (docker *dockerCmd) RunJob(ctx context.Context, req DeploymentRequest, eventID string) (*JobResult, error) {
+	return docker.startJob(ctx, req, eventID, false)
+}
+
+// startJob runs a job as RunJob does, runs may overlap when overlap is set as for cron jobs
+// under the Allow concurrency policy
+func (docker *dockerCmd) startJob(ctx context.Context, req DeploymentRequest, eventID string, overlap bool) (*JobResult, error) {
+	if !isJob(req) {
+		return nil, fmt.Errorf("%v is a %v, not a %v", deploymentName(req), req.Kind, KindJob)
+	}
+	if err := docker.Validate(req); err != nil {
+		return nil, err
+	}
+
+	revision := &Revision{
+		Request:   req,
+		EventID:   eventID,
+		StartedAt: time.Now(),
+	}
+
+	// The revision is recorded as running so that overlapping runs get their own number
+	lock := docker.deploymentLock(deploymentName(req))
+	lock.Lock()
+	if !overlap {
+		// One run at a time, the lock is held until the run is recorded
+		defer lock.Unlock()
+	}
+	number, err := docker.store.NextRevisionNumber(deploymentName(req))
+	if err == nil {
+		revision.Number = number
+		revision.Outcome = OutcomeRunning
+		err = docker.store.AddRevision(revision)
+	}
+	if overlap {
+		lock.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := docker.runJob(ctx, revision)
+
+	revision.FinishedAt = time.Now()
+	if result != nil {
+		result.Duration = revision.FinishedAt.Sub(revision.StartedAt)
+		revision.ExitCode = &result.ExitCode
+		revision.Logs = result.Logs
+	}
+	revision.Outcome = OutcomeCompleted
+	if err != nil {
+		revision.Outcome = OutcomeFailed
+		revision.Error = err.Error()
+	}
+
+	if err := docker.store.AddRevision(revision); err != nil {
+		log.Printf("Error recording revision %v of %v: %v\n", revision.Number, deploymentName(req), err)
+	}
+
+	return result, err
+}
+
+func (docker *dockerCmd) runJob(ctx context.Context, revision *Revision) (*JobResult, error) {
+	req := revision.Request
+
+	log.Println("Pulling image: ", req.Container.Image)
+	if err := docker.Pull(ctx, req.Container.Image); err != nil {
+		log.Printf("Error pulling from Docker registry: %v\n", err)
+		return nil, err
+	}
+
+	var err error
+	revision.ImageDigest, err = docker.imageDigest(ctx, req.Container.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := docker.ensureVolumes(ctx, req); err != nil {
+		return nil, err
+	}
+	if err := docker.ensureNetworks(ctx, req); err != nil {
+		return nil, err
+	}
+
+	// Every run gets its own container, removed once its logs are read
+	name := replicaName(req, 0) + "-" + strconv.Itoa(revision.Number)
+	docker.removeContainer(ctx, name)
+
+	template, err := docker.newReplicaTemplate(*revision, true)
+	if err != nil {
+		return nil, err
+	}
+
+	containerId, err := docker.createReplica(ctx, template, 0, name)
+	if containerId != "" {
+		revision.ContainerIDs = []string{containerId}
+		defer docker.removeContainer(context.WithoutCancel(ctx), containerId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Job %v started: %v\n", name, containerId)
+
+	result := &JobResult{ContainerID: containerId, ImageDigest: revision.ImageDigest, Revision: revision.Number}
+
+	waitErr := docker.waitJob(ctx, req, containerId, result)
+
+	logs, err := docker.containerLogs(context.WithoutCancel(ctx), containerId)
+	if err != nil {
+		log.Printf("Error reading the logs of job %v: %v\n", name, err)
+	}
+	result.Logs = logs
+
+	if waitErr != nil {
+		return result, waitErr
+	}
+	if result.ExitCode != 0 {
+		return result, fmt.Errorf("job exited with code %v after %v attempts", result.ExitCode, result.Attempts)
+	}
+
+	log.Printf("Job %v completed after %v attempts\n", name, result.Attempts)
+	return result, nil
+}
+
+// waitJob waits until the container of a job exits without being restarted, and kills it
+// once its deadline is exceeded
+func (docker *dockerCmd) waitJob(ctx context.Context, req DeploymentRequest, containerId string, result *JobResult) error {
+	if req.Spec.ActiveDeadlineSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.Spec.ActiveDeadlineSeconds)*time.Second)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		inspect, err := docker.cli.ContainerInspect(ctx, containerId)
+		if err == nil {
+			result.ExitCode = inspect.State.ExitCode
+			result.Attempts = inspect.RestartCount + 1
+
+			if !inspect.State.Running && !inspect.State.Restarting {
+				if inspect.State.OOMKilled {
+					return fmt.Errorf("job was killed for exceeding its memory limit of %v", req.Container.Resources.Memory)
+				}
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			docker.cli.ContainerKill(context.WithoutCancel(ctx), containerId, "KILL")
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("job exceeded its deadline of %v seconds", req.Spec.ActiveDeadlineSeconds)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// containerLogs returns the end of the combined output of a container
+func (docker *dockerCmd) containerLogs(ctx context.Context, containerId string) (string, error) {
+	out, err := docker.cli.ContainerLogs(ctx, containerId, containertypes.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	var logs bytes.Buffer
+	if _, err := stdcopy.StdCopy(&logs, &logs, out); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	if logs.Len() > maxJobLogs {
+		return string(logs.Bytes()[logs.Len()-maxJobLogs:]), nil
+	}
+	return logs.String(), nil
+}
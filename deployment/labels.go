@@ -0,0 +1,173 @@
+package deployment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"log"
+	"maps"
+	"strconv"
+)
+
+const (
+	// LabelManagedBy marks the containers created by the manager
+	LabelManagedBy = "io.bluerobin.managed-by"
+	// LabelDeployment holds the Metadata.Name of the deployment owning a container
+	LabelDeployment = "io.bluerobin.deployment"
+	// LabelReplica holds the replica index of a container within its deployment
+	LabelReplica = "io.bluerobin.replica"
+	// LabelRevision holds the revision number a container was created for
+	LabelRevision = "io.bluerobin.revision"
+	// LabelEventID holds the ID of the event that triggered the revision
+	LabelEventID = "io.bluerobin.event-id"
+	// LabelConfigHash holds the hash of the request and image digest of the revision
+	LabelConfigHash = "io.bluerobin.config-hash"
+	// LabelRequest holds the JSON request of the revision, used to rebuild the state
+	LabelRequest = "io.bluerobin.request"
+
+	managedBy = "DeploymentManager"
+)
+
+// replicaTemplate is what the replicas of a rollout are created from
+type replicaTemplate struct {
+	req     DeploymentRequest
+	envVars []string
+	files   []secretFile
+	labels  map[string]string
+}
+
+// newReplicaTemplate returns the template of a revision, with its image pinned by digest
+// when the image must not be pulled again
+func (docker *dockerCmd) newReplicaTemplate(revision Revision, pinned bool) (replicaTemplate, error) {
+	req := revision.Request
+	if pinned && revision.ImageDigest != "" {
+		req.Container.Image = revision.ImageDigest
+	}
+
+	envVars, err := docker.containerEnv(req)
+	if err != nil {
+		return replicaTemplate{}, err
+	}
+
+	files, err := docker.containerSecretFiles(req)
+	if err != nil {
+		return replicaTemplate{}, err
+	}
+
+	return replicaTemplate{
+		req:     req,
+		envVars: envVars,
+		files:   files,
+		labels:  revisionLabels(revision),
+	}, nil
+}
+
+// replicaLabels returns the labels of a replica of the template
+func (template replicaTemplate) replicaLabels(index int) map[string]string {
+	labels := maps.Clone(template.labels)
+	labels[LabelDeployment] = deploymentName(template.req)
+	labels[LabelReplica] = strconv.Itoa(index)
+	return labels
+}
+
+// revisionLabels returns the labels shared by every container of a revision
+func revisionLabels(revision Revision) map[string]string {
+	labels := map[string]string{
+		LabelManagedBy:  managedBy,
+		LabelRevision:   strconv.Itoa(revision.Number),
+		LabelConfigHash: configHash(revision),
+	}
+
+	if revision.EventID != "" {
+		labels[LabelEventID] = revision.EventID
+	}
+
+	if request, err := json.Marshal(revision.Request); err == nil {
+		labels[LabelRequest] = string(request)
+	}
+
+	return labels
+}
+
+// configHash identifies the desired configuration of a revision
+func configHash(revision Revision) string {
+	request, _ := json.Marshal(revision.Request)
+
+	hash := sha256.New()
+	hash.Write(request)
+	hash.Write([]byte(revision.ImageDigest))
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// RebuildState records the revisions found on managed containers that are missing from
+// the store, or newer than its current revision. Unmanaged containers are ignored. The
+// secret files of the removed containers are removed first.
+func (docker *dockerCmd) RebuildState(ctx context.Context) error {
+	if err := docker.pruneSecretFiles(ctx); err != nil {
+		log.Printf("Error removing the secret files of removed containers: %v\n", err)
+	}
+
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("label", LabelManagedBy+"="+managedBy)
+
+	containers, err := docker.cli.ContainerList(ctx, containertypes.ListOptions{All: true, Filters: filterArgs})
+	if err != nil {
+		return err
+	}
+
+	// Group the replicas of the latest revision of every deployment
+	found := map[string]*Revision{}
+	for _, container := range containers {
+		name := container.Labels[LabelDeployment]
+		number, err := strconv.Atoi(container.Labels[LabelRevision])
+		if name == "" || err != nil {
+			log.Printf("Ignoring container %v with incomplete labels\n", container.ID)
+			continue
+		}
+
+		revision, ok := found[name]
+		if ok && revision.Number > number {
+			continue
+		}
+
+		if !ok || revision.Number < number {
+			revision = &Revision{
+				Number:      number,
+				ImageDigest: container.ImageID,
+				EventID:     container.Labels[LabelEventID],
+				Outcome:     OutcomeRecovered,
+			}
+
+			if err := json.Unmarshal([]byte(container.Labels[LabelRequest]), &revision.Request); err != nil {
+				log.Printf("Ignoring container %v without a readable request: %v\n", container.ID, err)
+				continue
+			}
+			found[name] = revision
+		}
+
+		revision.ContainerIDs = append(revision.ContainerIDs, container.ID)
+	}
+
+	for name, revision := range found {
+		current, err := docker.store.Current(name)
+		if err != nil {
+			return err
+		}
+
+		if current != nil && current.Number >= revision.Number {
+			continue
+		}
+
+		log.Printf("Recovering revision %v of %v from its containers\n", revision.Number, name)
+		if err := docker.store.AddRevision(revision); err != nil {
+			return fmt.Errorf("error recovering %v: %w", name, err)
+		}
+	}
+
+	return nil
+}
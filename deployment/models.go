@@ -1,29 +1,248 @@
 package deployment
 
+// Kinds of request
+const (
+	// KindDeployment keeps replicas running, it is the default kind
+	KindDeployment = "Deployment"
+	// KindJob runs a container to completion
+	KindJob = "Job"
+	// KindCronJob runs its container as a job on a schedule
+	KindCronJob = "CronJob"
+)
+
 type DeploymentRequest struct {
-	Kind     string `yaml:"kind"`
+	Kind     string `json:"kind,omitempty" yaml:"kind"`
 	Metadata struct {
-		Name string `yaml:"name"`
-	} `yaml:"metadata"`
+		Name string `json:"name,omitempty" yaml:"name"`
+	} `json:"metadata,omitempty" yaml:"metadata"`
 	Spec struct {
-		Replicas int `yaml:"replicas"`
-	} `yaml:"spec"`
+		Replicas int      `json:"replicas,omitempty" yaml:"replicas"`
+		Strategy Strategy `json:"strategy,omitempty" yaml:"strategy"`
+		// BackoffLimit is the number of times a failed job is restarted
+		BackoffLimit int `json:"backoffLimit,omitempty" yaml:"backoffLimit"`
+		// ActiveDeadlineSeconds stops a job running for longer, unlimited when 0
+		ActiveDeadlineSeconds int `json:"activeDeadlineSeconds,omitempty" yaml:"activeDeadlineSeconds"`
+		// Schedule is the cron expression of a cron job, such as "*/15 * * * *"
+		Schedule string `json:"schedule,omitempty" yaml:"schedule"`
+		// ConcurrencyPolicy is Allow (default), Forbid or Replace when a run is still active
+		ConcurrencyPolicy string `json:"concurrencyPolicy,omitempty" yaml:"concurrencyPolicy"`
+		// HistoryLimit is the number of runs of a cron job kept, 10 by default
+		HistoryLimit *int `json:"historyLimit,omitempty" yaml:"historyLimit"`
+		// SecretReload is how the deployment reacts when one of its secrets is rotated
+		SecretReload SecretReload `json:"secretReload,omitempty" yaml:"secretReload"`
+	} `json:"spec,omitempty" yaml:"spec"`
 	Container struct {
-		Name          string `yaml:"name"`
-		Image         string `yaml:"image"`
-		Binding       string `yaml:"binding"`
-		ContainerPort string `yaml:"containerPort"`
-		HostPort      string `yaml:"hostPort"`
+		Name          string `json:"name,omitempty" yaml:"name"`
+		Image         string `json:"image,omitempty" yaml:"image"`
+		Binding       string `json:"binding,omitempty" yaml:"binding"`
+		ContainerPort string `json:"containerPort,omitempty" yaml:"containerPort"`
+		HostPort      string `json:"hostPort,omitempty" yaml:"hostPort"`
 		EnvVars       []struct {
-			Name  string `yaml:"name"`
-			Value string `yaml:"value"`
-		} `yaml:"envVars"`
-		Secrets []Secret `yaml:"secrets"`
-	} `yaml:"container"`
+			Name  string `json:"name,omitempty" yaml:"name"`
+			Value string `json:"value,omitempty" yaml:"value"`
+		} `json:"envVars,omitempty" yaml:"envVars"`
+		// Ports replaces Binding, ContainerPort and HostPort which describe a single TCP port
+		Ports          []Port    `json:"ports,omitempty" yaml:"ports"`
+		Secrets        []Secret  `json:"secrets,omitempty" yaml:"secrets"`
+		ReadinessProbe *Probe    `json:"readinessProbe,omitempty" yaml:"readinessProbe"`
+		LivenessProbe  *Probe    `json:"livenessProbe,omitempty" yaml:"livenessProbe"`
+		Volumes        []Volume  `json:"volumes,omitempty" yaml:"volumes"`
+		Resources      Resources `json:"resources,omitempty" yaml:"resources"`
+		// Networks defaults to the network of the manager
+		Networks []Network `json:"networks,omitempty" yaml:"networks"`
+		// Entrypoint and Command replace the ENTRYPOINT and CMD of the image
+		Entrypoint []string `json:"entrypoint,omitempty" yaml:"entrypoint"`
+		Command    []string `json:"command,omitempty" yaml:"command"`
+		User       string   `json:"user,omitempty" yaml:"user"`
+		WorkingDir string   `json:"workingDir,omitempty" yaml:"workingDir"`
+		Hostname   string   `json:"hostname,omitempty" yaml:"hostname"`
+		DNS        []string `json:"dns,omitempty" yaml:"dns"`
+		DNSSearch  []string `json:"dnsSearch,omitempty" yaml:"dnsSearch"`
+		// ExtraHosts are added to /etc/hosts as "hostname:IP"
+		ExtraHosts []string `json:"extraHosts,omitempty" yaml:"extraHosts"`
+		// StopSignal defaults to the STOPSIGNAL of the image
+		StopSignal string `json:"stopSignal,omitempty" yaml:"stopSignal"`
+		// StopGracePeriodSeconds is how long a container may take to stop before it is killed
+		StopGracePeriodSeconds *int          `json:"stopGracePeriodSeconds,omitempty" yaml:"stopGracePeriodSeconds"`
+		RestartPolicy          RestartPolicy `json:"restartPolicy,omitempty" yaml:"restartPolicy"`
+		// Security overrides the security defaults of the manager
+		Security Security `json:"security,omitempty" yaml:"security"`
+	} `json:"container,omitempty" yaml:"container"`
 }
 
+// Secret is injected from the secret manager as an environment variable
 type Secret struct {
-	SecretPath  string `json:"secretPath"`
-	SecretKey   string `json:"secretKey"`
-	SecretValue string `json:"secretValue,omitempty"`
+	SecretPath string `json:"secretPath,omitempty" yaml:"secretPath"`
+	SecretKey  string `json:"secretKey,omitempty" yaml:"secretKey"`
+	// Name is the environment variable, or the file name, SecretKey by default
+	Name string `json:"name,omitempty" yaml:"name"`
+	// Delivery is env (default) to inject the secret as an environment variable, or file
+	// to write it to an in-memory file
+	Delivery string `json:"delivery,omitempty" yaml:"delivery"`
+	// Path of the file, relative to /run/secrets, defaults to /run/secrets/<name>
+	Path string `json:"path,omitempty" yaml:"path"`
+	// Mode of the file in octal, 0400 by default
+	Mode string `json:"mode,omitempty" yaml:"mode"`
+	// Owner of the file as uid[:gid], root by default
+	Owner string `json:"owner,omitempty" yaml:"owner"`
+	// SecretValue is only found in legacy records, it is never stored nor published
+	SecretValue string `json:"-" yaml:"-"`
+}
+
+// SecretReload controls how a deployment reacts to the rotation of its secrets
+type SecretReload struct {
+	// Strategy is recreate, signal or none. By default deployments with secrets in their
+	// environment are recreated, and the others only get their secret files updated.
+	Strategy string `json:"strategy,omitempty" yaml:"strategy"`
+	// Signal is sent by the signal strategy once the secret files are updated, SIGHUP by default
+	Signal string `json:"signal,omitempty" yaml:"signal"`
+}
+
+// Strategy controls how the replicas of a deployment are replaced
+type Strategy struct {
+	// MaxSurge is the number of replicas started next to the old ones, defaults to 1
+	MaxSurge *int `json:"maxSurge,omitempty" yaml:"maxSurge"`
+	// MaxUnavailable is the number of old replicas stopped before their replacement starts
+	MaxUnavailable int `json:"maxUnavailable,omitempty" yaml:"maxUnavailable"`
+	// MinReadySeconds is how long a new container must keep running before it replaces the old one
+	MinReadySeconds *int `json:"minReadySeconds,omitempty" yaml:"minReadySeconds"`
+}
+
+// Probe checks a container with one of HTTPGet, TCPSocket or Exec
+type Probe struct {
+	HTTPGet             *HTTPGetAction   `json:"httpGet,omitempty" yaml:"httpGet"`
+	TCPSocket           *TCPSocketAction `json:"tcpSocket,omitempty" yaml:"tcpSocket"`
+	Exec                *ExecAction      `json:"exec,omitempty" yaml:"exec"`
+	InitialDelaySeconds int              `json:"initialDelaySeconds,omitempty" yaml:"initialDelaySeconds"`
+	PeriodSeconds       int              `json:"periodSeconds,omitempty" yaml:"periodSeconds"`
+	TimeoutSeconds      int              `json:"timeoutSeconds,omitempty" yaml:"timeoutSeconds"`
+	FailureThreshold    int              `json:"failureThreshold,omitempty" yaml:"failureThreshold"`
+}
+
+// HTTPGetAction succeeds on a 2xx or 3xx response
+type HTTPGetAction struct {
+	// Host defaults to the container IP address
+	Host   string `json:"host,omitempty" yaml:"host"`
+	Port   int    `json:"port,omitempty" yaml:"port"`
+	Path   string `json:"path,omitempty" yaml:"path"`
+	Scheme string `json:"scheme,omitempty" yaml:"scheme"`
+}
+
+// TCPSocketAction succeeds when a connection can be opened
+type TCPSocketAction struct {
+	// Host defaults to the container IP address
+	Host string `json:"host,omitempty" yaml:"host"`
+	Port int    `json:"port,omitempty" yaml:"port"`
+}
+
+// ExecAction succeeds when the command exits with 0 inside the container
+type ExecAction struct {
+	Command []string `json:"command,omitempty" yaml:"command"`
+}
+
+// Port exposes a container port or range, and binds it to the host when HostPort is set
+type Port struct {
+	// ContainerPort is a port such as "8080" or a range such as "8000-8010"
+	ContainerPort string `json:"containerPort,omitempty" yaml:"containerPort"`
+	// Protocol is tcp (default) or udp
+	Protocol string `json:"protocol,omitempty" yaml:"protocol"`
+	// HostPort is a port or range of the same size. With replicas, a range as large as
+	// all the replicas gives each replica its own ports, otherwise only the first one binds.
+	HostPort string `json:"hostPort,omitempty" yaml:"hostPort"`
+	// HostIPs are the IPv4 or IPv6 addresses to bind, all interfaces when empty
+	HostIPs []string `json:"hostIPs,omitempty" yaml:"hostIPs"`
+}
+
+// Volume mounts a named volume, a host path or a tmpfs into the container
+type Volume struct {
+	// Type is volume (default), bind or tmpfs
+	Type string `json:"type,omitempty" yaml:"type"`
+	// Source is the volume name or the host path, unused for tmpfs
+	Source string `json:"source,omitempty" yaml:"source"`
+	// Target is the path inside the container
+	Target   string `json:"target,omitempty" yaml:"target"`
+	ReadOnly bool   `json:"readOnly,omitempty" yaml:"readOnly"`
+	// Driver and DriverOpts are used when the named volume is created
+	Driver     string            `json:"driver,omitempty" yaml:"driver"`
+	DriverOpts map[string]string `json:"driverOpts,omitempty" yaml:"driverOpts"`
+	// Size limits a tmpfs, such as "64m"
+	Size string `json:"size,omitempty" yaml:"size"`
+	// Mode is the octal file mode of a tmpfs, such as "1777"
+	Mode string `json:"mode,omitempty" yaml:"mode"`
+}
+
+// Resources limits what the containers of a deployment may use on the host
+type Resources struct {
+	// Memory is the hard memory limit, such as "512m"
+	Memory string `json:"memory,omitempty" yaml:"memory"`
+	// MemoryReservation is the soft memory limit enforced when the host is short on memory
+	MemoryReservation string `json:"memoryReservation,omitempty" yaml:"memoryReservation"`
+	// CPUs is the CPU quota in number of CPUs, such as "0.5"
+	CPUs string `json:"cpus,omitempty" yaml:"cpus"`
+	// CPUShares is the CPU weight relative to other containers, 1024 by default
+	CPUShares int64 `json:"cpuShares,omitempty" yaml:"cpuShares"`
+	// CpusetCpus restricts the CPUs the containers run on, such as "0-2" or "0,1"
+	CpusetCpus string `json:"cpusetCpus,omitempty" yaml:"cpusetCpus"`
+	// PidsLimit is the maximum number of processes, -1 for unlimited
+	PidsLimit *int64   `json:"pidsLimit,omitempty" yaml:"pidsLimit"`
+	Ulimits   []Ulimit `json:"ulimits,omitempty" yaml:"ulimits"`
+}
+
+// Ulimit sets the soft and hard limits of a resource such as nofile or nproc
+type Ulimit struct {
+	Name string `json:"name,omitempty" yaml:"name"`
+	Soft int64  `json:"soft,omitempty" yaml:"soft"`
+	Hard int64  `json:"hard,omitempty" yaml:"hard"`
+}
+
+// Network attaches the containers to a network, created with Driver, DriverOpts and IPAM
+// when it does not exist
+type Network struct {
+	Name    string   `json:"name,omitempty" yaml:"name"`
+	Aliases []string `json:"aliases,omitempty" yaml:"aliases"`
+	// IPv4Address and IPv6Address are static addresses, only allowed with a single replica
+	IPv4Address string            `json:"ipv4Address,omitempty" yaml:"ipv4Address"`
+	IPv6Address string            `json:"ipv6Address,omitempty" yaml:"ipv6Address"`
+	Driver      string            `json:"driver,omitempty" yaml:"driver"`
+	DriverOpts  map[string]string `json:"driverOpts,omitempty" yaml:"driverOpts"`
+	Internal    bool              `json:"internal,omitempty" yaml:"internal"`
+	EnableIPv6  bool              `json:"enableIPv6,omitempty" yaml:"enableIPv6"`
+	IPAM        *IPAM             `json:"ipam,omitempty" yaml:"ipam"`
+}
+
+// IPAM configures the addresses of a network
+type IPAM struct {
+	Driver string       `json:"driver,omitempty" yaml:"driver"`
+	Config []IPAMConfig `json:"config,omitempty" yaml:"config"`
+}
+
+// IPAMConfig is a subnet of a network
+type IPAMConfig struct {
+	Subnet  string `json:"subnet,omitempty" yaml:"subnet"`
+	IPRange string `json:"ipRange,omitempty" yaml:"ipRange"`
+	Gateway string `json:"gateway,omitempty" yaml:"gateway"`
+}
+
+// RestartPolicy restarts the containers when they exit
+type RestartPolicy struct {
+	// Name is always (default), unless-stopped, on-failure or no
+	Name string `json:"name,omitempty" yaml:"name"`
+	// MaximumRetryCount limits the restarts of the on-failure policy
+	MaximumRetryCount int `json:"maximumRetryCount,omitempty" yaml:"maximumRetryCount"`
+}
+
+// Security hardens the containers, unset fields use the defaults of the manager
+type Security struct {
+	ReadOnlyRootFilesystem *bool    `json:"readOnlyRootFilesystem,omitempty" yaml:"readOnlyRootFilesystem"`
+	NoNewPrivileges        *bool    `json:"noNewPrivileges,omitempty" yaml:"noNewPrivileges"`
+	CapAdd                 []string `json:"capAdd,omitempty" yaml:"capAdd"`
+	CapDrop                []string `json:"capDrop,omitempty" yaml:"capDrop"`
+	// SeccompProfile is unconfined or the path of a JSON profile readable by the manager
+	SeccompProfile  string `json:"seccompProfile,omitempty" yaml:"seccompProfile"`
+	AppArmorProfile string `json:"appArmorProfile,omitempty" yaml:"appArmorProfile"`
+	// Privileged is refused unless the manager allows it
+	Privileged bool `json:"privileged,omitempty" yaml:"privileged"`
+	// UsernsMode host disables user namespace remapping, refused unless the manager allows
+	// privileged containers
+	UsernsMode string `json:"usernsMode,omitempty" yaml:"usernsMode"`
 }
@@ -0,0 +1,102 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"log"
+)
+
+const defaultNetwork = "bluerobin"
+
+// replicaNetworks returns the networks of a request, the default network when it lists none
+func replicaNetworks(req DeploymentRequest, defaultName string) ([]Network, error) {
+	networks := req.Container.Networks
+	if len(networks) == 0 {
+		return []Network{{Name: defaultName}}, nil
+	}
+
+	for _, net := range networks {
+		if net.Name == "" {
+			return nil, fmt.Errorf("network has no name")
+		}
+		if hasStaticIP(net) && replicaCount(req) > 1 {
+			return nil, fmt.Errorf("network %v: static addresses require a single replica", net.Name)
+		}
+	}
+
+	return networks, nil
+}
+
+func hasStaticIP(net Network) bool {
+	return net.IPv4Address != "" || net.IPv6Address != ""
+}
+
+// usesStaticIP reports whether the containers of a request have a static address, which two
+// containers cannot hold at once
+func usesStaticIP(req DeploymentRequest) bool {
+	for _, net := range req.Container.Networks {
+		if hasStaticIP(net) {
+			return true
+		}
+	}
+	return false
+}
+
+// endpointSettings returns the settings of a container on a network
+func endpointSettings(net Network) *network.EndpointSettings {
+	settings := &network.EndpointSettings{Aliases: net.Aliases}
+	if hasStaticIP(net) {
+		settings.IPAMConfig = &network.EndpointIPAMConfig{
+			IPv4Address: net.IPv4Address,
+			IPv6Address: net.IPv6Address,
+		}
+	}
+	return settings
+}
+
+// ensureNetworks creates the networks of a request that do not exist yet
+func (docker *dockerCmd) ensureNetworks(ctx context.Context, req DeploymentRequest) error {
+	networks, err := replicaNetworks(req, docker.network)
+	if err != nil {
+		return err
+	}
+
+	for _, net := range networks {
+		_, err := docker.cli.NetworkInspect(ctx, net.Name, network.InspectOptions{})
+		if err == nil {
+			continue
+		}
+		if !errdefs.IsNotFound(err) {
+			return err
+		}
+
+		options := network.CreateOptions{
+			Driver:   net.Driver,
+			Options:  net.DriverOpts,
+			Internal: net.Internal,
+			Labels:   map[string]string{LabelManagedBy: managedBy},
+		}
+		if net.EnableIPv6 {
+			options.EnableIPv6 = &net.EnableIPv6
+		}
+		if net.IPAM != nil {
+			options.IPAM = &network.IPAM{Driver: net.IPAM.Driver}
+			for _, config := range net.IPAM.Config {
+				options.IPAM.Config = append(options.IPAM.Config, network.IPAMConfig{
+					Subnet:  config.Subnet,
+					IPRange: config.IPRange,
+					Gateway: config.Gateway,
+				})
+			}
+		}
+
+		log.Printf("Creating network: %v\n", net.Name)
+		if _, err := docker.cli.NetworkCreate(ctx, net.Name, options); err != nil {
+			return fmt.Errorf("error creating network %v: %w", net.Name, err)
+		}
+	}
+
+	return nil
+}
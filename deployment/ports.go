@@ -0,0 +1,101 @@
+package deployment
+
+import (
+	"fmt"
+	"github.com/docker/go-connections/nat"
+	"strconv"
+	"strings"
+)
+
+const defaultProtocol = "tcp"
+
+// containerPorts returns the ports of a request, including the legacy single port fields
+func containerPorts(req DeploymentRequest) []Port {
+	ports := req.Container.Ports
+
+	if req.Container.ContainerPort != "" {
+		legacy := Port{
+			ContainerPort: req.Container.ContainerPort,
+			HostPort:      req.Container.HostPort,
+		}
+		if req.Container.Binding != "" {
+			legacy.HostIPs = []string{req.Container.Binding}
+		}
+		ports = append([]Port{legacy}, ports...)
+	}
+
+	return ports
+}
+
+// replicaPorts returns the exposed ports and port bindings of a replica
+func replicaPorts(req DeploymentRequest, index int) (nat.PortSet, nat.PortMap, error) {
+	exposedPorts := nat.PortSet{}
+	portBindings := nat.PortMap{}
+
+	for _, port := range containerPorts(req) {
+		protocol := strings.ToLower(port.Protocol)
+		if protocol == "" {
+			protocol = defaultProtocol
+		}
+
+		containerStart, containerEnd, err := nat.ParsePortRange(port.ContainerPort)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid containerPort %q: %w", port.ContainerPort, err)
+		}
+
+		hostStart, bound, err := replicaHostPortStart(port, containerEnd-containerStart+1, index, replicaCount(req))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for offset := uint64(0); offset <= containerEnd-containerStart; offset++ {
+			containerPort := nat.Port(strconv.FormatUint(containerStart+offset, 10) + "/" + protocol)
+			exposedPorts[containerPort] = struct{}{}
+
+			if !bound {
+				continue
+			}
+
+			hostPort := strconv.FormatUint(hostStart+offset, 10)
+			if len(port.HostIPs) == 0 {
+				portBindings[containerPort] = append(portBindings[containerPort], nat.PortBinding{HostPort: hostPort})
+			}
+			for _, hostIP := range port.HostIPs {
+				portBindings[containerPort] = append(portBindings[containerPort], nat.PortBinding{HostIP: hostIP, HostPort: hostPort})
+			}
+		}
+	}
+
+	return exposedPorts, portBindings, nil
+}
+
+// replicaHostPortStart returns the first host port bound by a replica for a port of size
+// ports. A host range of the same size is only bound by the first replica, a range as large
+// as every replica gives each replica its own slice.
+func replicaHostPortStart(port Port, size uint64, index int, replicas int) (uint64, bool, error) {
+	if port.HostPort == "" {
+		return 0, false, nil
+	}
+
+	hostStart, hostEnd, err := nat.ParsePortRange(port.HostPort)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid hostPort %q: %w", port.HostPort, err)
+	}
+
+	hostSize := hostEnd - hostStart + 1
+
+	switch {
+	case replicas > 1 && hostSize >= size*uint64(replicas):
+		return hostStart + uint64(index)*size, true, nil
+	case hostSize == size:
+		return hostStart, index == 0, nil
+	}
+
+	return 0, false, fmt.Errorf("hostPort %q does not match containerPort %q for %d replicas", port.HostPort, port.ContainerPort, replicas)
+}
+
+// bindsHostPorts reports whether a replica binds any host port
+func bindsHostPorts(req DeploymentRequest, index int) (bool, error) {
+	_, portBindings, err := replicaPorts(req, index)
+	return len(portBindings) > 0, err
+}
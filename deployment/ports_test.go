@@ -0,0 +1,125 @@
+package deployment
+
+import (
+	"encoding/json"
+	"github.com/docker/go-connections/nat"
+	"reflect"
+	"testing"
+)
+
+func TestReplicaHostPortStart(t *testing.T) {
+	tests := []struct {
+		name      string
+		port      Port
+		size      uint64
+		index     int
+		replicas  int
+		wantStart uint64
+		wantBound bool
+		wantErr   bool
+	}{
+		{name: "no host port", port: Port{ContainerPort: "80"}, size: 1, replicas: 1},
+		{name: "single port", port: Port{ContainerPort: "80", HostPort: "8080"}, size: 1, replicas: 1, wantStart: 8080, wantBound: true},
+		{name: "single port bound by the first replica", port: Port{ContainerPort: "80", HostPort: "8080"}, size: 1, replicas: 3, wantStart: 8080, wantBound: true},
+		{name: "single port not bound by the other replicas", port: Port{ContainerPort: "80", HostPort: "8080"}, size: 1, index: 2, replicas: 3, wantStart: 8080},
+		{name: "range of the same size", port: Port{ContainerPort: "5000-5001", HostPort: "6000-6001"}, size: 2, replicas: 1, wantStart: 6000, wantBound: true},
+		{name: "range sliced per replica", port: Port{ContainerPort: "5000-5001", HostPort: "6000-6005"}, size: 2, index: 2, replicas: 3, wantStart: 6004, wantBound: true},
+		{name: "range larger than every replica", port: Port{ContainerPort: "80", HostPort: "8080-8089"}, size: 1, index: 1, replicas: 2, wantStart: 8081, wantBound: true},
+		{name: "range too small", port: Port{ContainerPort: "5000-5001", HostPort: "6000-6002"}, size: 2, replicas: 1, wantErr: true},
+		{name: "invalid host port", port: Port{ContainerPort: "80", HostPort: "http"}, size: 1, replicas: 1, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start, bound, err := replicaHostPortStart(test.port, test.size, test.index, test.replicas)
+
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("replicaHostPortStart = %v, %v, want an error", start, bound)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if start != test.wantStart || bound != test.wantBound {
+				t.Errorf("replicaHostPortStart = %v, %v, want %v, %v", start, bound, test.wantStart, test.wantBound)
+			}
+		})
+	}
+}
+
+func TestReplicaPorts(t *testing.T) {
+	tests := []struct {
+		name         string
+		request      string
+		index        int
+		wantExposed  nat.PortSet
+		wantBindings nat.PortMap
+		wantErr      bool
+	}{
+		{
+			name:         "legacy single port",
+			request:      `{"container": {"binding": "127.0.0.1", "containerPort": "80", "hostPort": "8080"}}`,
+			wantExposed:  nat.PortSet{"80/tcp": {}},
+			wantBindings: nat.PortMap{"80/tcp": {{HostIP: "127.0.0.1", HostPort: "8080"}}},
+		},
+		{
+			name:         "exposed only",
+			request:      `{"container": {"ports": [{"containerPort": "9090"}]}}`,
+			wantExposed:  nat.PortSet{"9090/tcp": {}},
+			wantBindings: nat.PortMap{},
+		},
+		{
+			name:         "UDP range of the second replica",
+			request:      `{"spec": {"replicas": 2}, "container": {"ports": [{"containerPort": "5000-5001", "hostPort": "6000-6003", "protocol": "UDP"}]}}`,
+			index:        1,
+			wantExposed:  nat.PortSet{"5000/udp": {}, "5001/udp": {}},
+			wantBindings: nat.PortMap{"5000/udp": {{HostPort: "6002"}}, "5001/udp": {{HostPort: "6003"}}},
+		},
+		{
+			name:         "several host addresses",
+			request:      `{"container": {"ports": [{"containerPort": "443", "hostPort": "8443", "hostIPs": ["0.0.0.0", "::"]}]}}`,
+			wantExposed:  nat.PortSet{"443/tcp": {}},
+			wantBindings: nat.PortMap{"443/tcp": {{HostIP: "0.0.0.0", HostPort: "8443"}, {HostIP: "::", HostPort: "8443"}}},
+		},
+		{
+			name:         "TCP and UDP on the same port",
+			request:      `{"container": {"ports": [{"containerPort": "53", "hostPort": "53"}, {"containerPort": "53", "hostPort": "53", "protocol": "udp"}]}}`,
+			wantExposed:  nat.PortSet{"53/tcp": {}, "53/udp": {}},
+			wantBindings: nat.PortMap{"53/tcp": {{HostPort: "53"}}, "53/udp": {{HostPort: "53"}}},
+		},
+		{
+			name:    "invalid container port",
+			request: `{"container": {"ports": [{"containerPort": "http"}]}}`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req DeploymentRequest
+			if err := json.Unmarshal([]byte(test.request), &req); err != nil {
+				t.Fatal(err)
+			}
+
+			exposed, bindings, err := replicaPorts(req, test.index)
+
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("replicaPorts = %v, %v, want an error", exposed, bindings)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(exposed, test.wantExposed) {
+				t.Errorf("exposed ports = %v, want %v", exposed, test.wantExposed)
+			}
+			if !reflect.DeepEqual(bindings, test.wantBindings) {
+				t.Errorf("port bindings = %v, want %v", bindings, test.wantBindings)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultProbePeriod           = 10 * time.Second
+	defaultProbeTimeout          = 1 * time.Second
+	defaultProbeFailureThreshold = 3
+)
+
+func (probe *Probe) initialDelay() time.Duration {
+	return time.Duration(max(probe.InitialDelaySeconds, 0)) * time.Second
+}
+
+func (probe *Probe) period() time.Duration {
+	if probe.PeriodSeconds <= 0 {
+		return defaultProbePeriod
+	}
+	return time.Duration(probe.PeriodSeconds) * time.Second
+}
+
+func (probe *Probe) timeout() time.Duration {
+	if probe.TimeoutSeconds <= 0 {
+		return defaultProbeTimeout
+	}
+	return time.Duration(probe.TimeoutSeconds) * time.Second
+}
+
+func (probe *Probe) failureThreshold() int {
+	if probe.FailureThreshold <= 0 {
+		return defaultProbeFailureThreshold
+	}
+	return probe.FailureThreshold
+}
+
+// healthConfig maps a liveness probe to a Docker HEALTHCHECK.
+// HTTP and TCP checks run inside the container and need wget or nc in the image.
+func healthConfig(probe *Probe) *containertypes.HealthConfig {
+	if probe == nil {
+		return nil
+	}
+
+	var test []string
+	switch {
+	case probe.Exec != nil:
+		test = append([]string{"CMD"}, probe.Exec.Command...)
+	case probe.HTTPGet != nil:
+		url := fmt.Sprintf("%s://localhost:%d%s", httpScheme(probe.HTTPGet), probe.HTTPGet.Port, httpPath(probe.HTTPGet))
+		test = []string{"CMD-SHELL", "wget -q -O /dev/null " + url + " || exit 1"}
+	case probe.TCPSocket != nil:
+		test = []string{"CMD-SHELL", fmt.Sprintf("nc -z localhost %d || exit 1", probe.TCPSocket.Port)}
+	default:
+		return nil
+	}
+
+	return &containertypes.HealthConfig{
+		Test:        test,
+		Interval:    probe.period(),
+		Timeout:     probe.timeout(),
+		StartPeriod: probe.initialDelay(),
+		Retries:     probe.failureThreshold(),
+	}
+}
+
+// waitReady runs a readiness probe until it succeeds, the container stops or the
+// failure threshold is reached
+func (docker *dockerCmd) waitReady(ctx context.Context, containerId string, probe *Probe) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(probe.initialDelay()):
+	}
+
+	failures := 0
+	for {
+		inspect, err := docker.cli.ContainerInspect(ctx, containerId)
+		if err != nil {
+			return err
+		}
+		if !inspect.State.Running {
+			return fmt.Errorf("container is %v (exit code %v) before becoming ready", inspect.State.Status, inspect.State.ExitCode)
+		}
+
+		err = docker.runProbe(ctx, containerIP(inspect.NetworkSettings), containerId, probe)
+		if err == nil {
+			return nil
+		}
+
+		failures++
+		log.Printf("Readiness probe of %v failed (%v/%v): %v\n", containerId, failures, probe.failureThreshold(), err)
+		if failures >= probe.failureThreshold() {
+			return fmt.Errorf("readiness probe failed: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(probe.period()):
+		}
+	}
+}
+
+// runProbe runs a single probe attempt
+func (docker *dockerCmd) runProbe(ctx context.Context, ip string, containerId string, probe *Probe) error {
+	ctx, cancel := context.WithTimeout(ctx, probe.timeout())
+	defer cancel()
+
+	switch {
+	case probe.HTTPGet != nil:
+		return httpProbe(ctx, probeHost(probe.HTTPGet.Host, ip), probe.HTTPGet)
+	case probe.TCPSocket != nil:
+		return tcpProbe(ctx, probeHost(probe.TCPSocket.Host, ip), probe.TCPSocket.Port)
+	case probe.Exec != nil:
+		return docker.execProbe(ctx, containerId, probe.Exec.Command)
+	}
+
+	return errors.New("probe has no httpGet, tcpSocket or exec action")
+}
+
+func httpProbe(ctx context.Context, host string, action *HTTPGetAction) error {
+	url := fmt.Sprintf("%s://%s%s", httpScheme(action), net.JoinHostPort(host, strconv.Itoa(action.Port)), httpPath(action))
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 400 {
+		return fmt.Errorf("GET %v returned %v", url, response.Status)
+	}
+
+	return nil
+}
+
+func tcpProbe(ctx context.Context, host string, port int) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+func (docker *dockerCmd) execProbe(ctx context.Context, containerId string, command []string) error {
+	exec, err := docker.cli.ContainerExecCreate(ctx, containerId, containertypes.ExecOptions{Cmd: command})
+	if err != nil {
+		return err
+	}
+
+	if err := docker.cli.ContainerExecStart(ctx, exec.ID, containertypes.ExecStartOptions{Detach: true}); err != nil {
+		return err
+	}
+
+	for {
+		inspect, err := docker.cli.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			return err
+		}
+
+		if !inspect.Running {
+			if inspect.ExitCode != 0 {
+				return fmt.Errorf("%v exited with code %v", strings.Join(command, " "), inspect.ExitCode)
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func httpScheme(action *HTTPGetAction) string {
+	if action.Scheme == "" {
+		return "http"
+	}
+	return strings.ToLower(action.Scheme)
+}
+
+func httpPath(action *HTTPGetAction) string {
+	if !strings.HasPrefix(action.Path, "/") {
+		return "/" + action.Path
+	}
+	return action.Path
+}
+
+func probeHost(host string, ip string) string {
+	if host != "" {
+		return host
+	}
+	return ip
+}
+
+// containerIP returns the first IP address of a container, in network name order
+func containerIP(settings *types.NetworkSettings) string {
+	if settings == nil {
+		return ""
+	}
+
+	names := make([]string, 0, len(settings.Networks))
+	for name := range settings.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if endpoint := settings.Networks[name]; endpoint != nil && endpoint.IPAddress != "" {
+			return endpoint.IPAddress
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,236 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of drift between a stored revision and its containers
+const (
+	DriftMissing = "missing"
+	DriftStopped = "stopped"
+	DriftImage   = "image"
+	DriftEnv     = "env"
+	DriftPorts   = "ports"
+	DriftNetwork = "network"
+	DriftExtra   = "extra"
+	DriftConfig  = "config"
+	DriftOOM     = "oom-killed"
+)
+
+// Drift is a difference between the desired state of a deployment and a container
+type Drift struct {
+	Deployment string `json:"deployment"`
+	Container  string `json:"container"`
+	Kind       string `json:"kind"`
+	Detail     string `json:"detail"`
+}
+
+func (drift Drift) String() string {
+	return fmt.Sprintf("%v/%v %v: %v", drift.Deployment, drift.Container, drift.Kind, drift.Detail)
+}
+
+// Reconcile compares the current revision of every deployment with its containers and,
+// when repair is set, deploys the drifted revisions again. Deployments being changed
+// are skipped until the next run.
+func (docker *dockerCmd) Reconcile(ctx context.Context, repair bool) ([]Drift, error) {
+	revisions, err := docker.store.CurrentRevisions()
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []Drift
+
+	for _, revision := range revisions {
+		// Jobs are not expected to keep running
+		if isJob(revision.Request) {
+			continue
+		}
+
+		name := deploymentName(revision.Request)
+
+		lock := docker.deploymentLock(name)
+		if !lock.TryLock() {
+			continue
+		}
+
+		found, err := docker.deploymentDrift(ctx, revision)
+		if err == nil && repair && len(found) > 0 {
+			err = docker.repair(ctx, revision)
+		}
+		lock.Unlock()
+
+		if err != nil {
+			log.Printf("Error reconciling %v: %v\n", name, err)
+		}
+
+		drifts = append(drifts, found...)
+	}
+
+	return drifts, nil
+}
+
+// deploymentDrift lists the drift of every replica of a revision
+func (docker *dockerCmd) deploymentDrift(ctx context.Context, revision Revision) ([]Drift, error) {
+	req := revision.Request
+	var drifts []Drift
+
+	envVars, err := docker.driftEnv(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for index := 0; index < replicaCount(req); index++ {
+		name := replicaName(req, index)
+
+		inspect, err := docker.cli.ContainerInspect(ctx, name)
+		if errdefs.IsNotFound(err) {
+			drifts = append(drifts, Drift{deploymentName(req), name, DriftMissing, "container does not exist"})
+			continue
+		}
+		if err != nil {
+			return drifts, err
+		}
+
+		found, err := replicaDrift(revision, index, inspect, envVars, docker.network)
+		if err != nil {
+			return drifts, err
+		}
+		drifts = append(drifts, found...)
+	}
+
+	// Replicas above the requested count
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("label", LabelDeployment+"="+deploymentName(req))
+	containers, err := docker.cli.ContainerList(ctx, containertypes.ListOptions{All: true, Filters: filterArgs})
+	if err != nil {
+		return drifts, err
+	}
+
+	for _, container := range containers {
+		index, err := strconv.Atoi(container.Labels[LabelReplica])
+		if err == nil && index >= replicaCount(req) {
+			drifts = append(drifts, Drift{deploymentName(req), strings.TrimPrefix(container.Names[0], "/"), DriftExtra, "replica " + strconv.Itoa(index) + " is not requested"})
+		}
+	}
+
+	return drifts, nil
+}
+
+// driftEnv returns the environment expected in the containers of a request. A deployment
+// reloading no secret keeps the values it was created with, so they are left out.
+func (docker *dockerCmd) driftEnv(req DeploymentRequest) ([]string, error) {
+	if secretReloadStrategy(req) == SecretReloadNone {
+		return plainEnv(req), nil
+	}
+	return docker.containerEnv(req)
+}
+
+// replicaDrift compares a container with the configuration of its replica and its resolved
+// environment
+func replicaDrift(revision Revision, index int, inspect types.ContainerJSON, envVars []string, defaultNetwork string) ([]Drift, error) {
+	req := revision.Request
+	imageDigest := revision.ImageDigest
+	deployment := deploymentName(req)
+	name := replicaName(req, index)
+	var drifts []Drift
+
+	if hash := inspect.Config.Labels[LabelConfigHash]; hash != configHash(revision) {
+		drifts = append(drifts, Drift{deployment, name, DriftConfig, "created from another configuration"})
+	}
+
+	if !inspect.State.Running {
+		drifts = append(drifts, Drift{deployment, name, DriftStopped, "container is " + inspect.State.Status})
+	}
+
+	if inspect.State.OOMKilled {
+		drifts = append(drifts, Drift{deployment, name, DriftOOM, "killed for exceeding its memory limit of " + req.Container.Resources.Memory})
+	}
+
+	if imageDigest != "" && inspect.Image != imageDigest {
+		drifts = append(drifts, Drift{deployment, name, DriftImage, fmt.Sprintf("running %v instead of %v", inspect.Image, imageDigest)})
+	}
+
+	// Only the names are reported, values may be secrets
+	var envDrift []string
+	for _, envVar := range envVars {
+		if !slices.Contains(inspect.Config.Env, envVar) {
+			envDrift = append(envDrift, strings.SplitN(envVar, "=", 2)[0])
+		}
+	}
+	if len(envDrift) > 0 {
+		drifts = append(drifts, Drift{deployment, name, DriftEnv, "differs for " + strings.Join(envDrift, ", ")})
+	}
+
+	_, portBindings, err := replicaPorts(req, index)
+	if err != nil {
+		return drifts, err
+	}
+	for port, bindings := range portBindings {
+		if !slices.Equal(inspect.HostConfig.PortBindings[port], bindings) {
+			drifts = append(drifts, Drift{deployment, name, DriftPorts, fmt.Sprintf("%v is bound to %v instead of %v", port, inspect.HostConfig.PortBindings[port], bindings)})
+		}
+	}
+
+	networks, err := replicaNetworks(req, defaultNetwork)
+	if err != nil {
+		return drifts, err
+	}
+	for _, net := range networks {
+		settings, ok := inspect.NetworkSettings.Networks[net.Name]
+		switch {
+		case !ok:
+			drifts = append(drifts, Drift{deployment, name, DriftNetwork, "not connected to " + net.Name})
+		case net.IPv4Address != "" && settings.IPAddress != net.IPv4Address:
+			drifts = append(drifts, Drift{deployment, name, DriftNetwork, fmt.Sprintf("has address %v instead of %v on %v", settings.IPAddress, net.IPv4Address, net.Name)})
+		case net.IPv6Address != "" && settings.GlobalIPv6Address != net.IPv6Address:
+			drifts = append(drifts, Drift{deployment, name, DriftNetwork, fmt.Sprintf("has address %v instead of %v on %v", settings.GlobalIPv6Address, net.IPv6Address, net.Name)})
+		}
+	}
+
+	return drifts, nil
+}
+
+// repair deploys the current revision again with its image pinned by digest, and
+// records it as a repaired revision
+func (docker *dockerCmd) repair(ctx context.Context, revision Revision) error {
+	name := deploymentName(revision.Request)
+	log.Printf("Repairing %v with revision %v\n", name, revision.Number)
+
+	number, err := docker.store.NextRevisionNumber(name)
+	if err != nil {
+		return err
+	}
+
+	repaired := revision
+	repaired.Number = number
+	repaired.EventID = ""
+	repaired.StartedAt = time.Now()
+	template, err := docker.newReplicaTemplate(repaired, true)
+	if err != nil {
+		return err
+	}
+
+	result, err := docker.rollout(ctx, template)
+	if err == nil {
+		err = docker.removeExtraReplicas(ctx, template.req)
+	}
+	if err != nil {
+		return err
+	}
+
+	repaired.ContainerIDs = result.ContainerIDs
+	repaired.FinishedAt = time.Now()
+	repaired.Outcome = OutcomeRepaired
+
+	return docker.store.AddRevision(&repaired)
+}
@@ -0,0 +1,75 @@
+package deployment
+
+import (
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"slices"
+	"testing"
+)
+
+func TestSecretReloadEnvDrift(t *testing.T) {
+	// The secret was rotated after the containers were created
+	docker := &dockerCmd{
+		network: defaultNetwork,
+		secrets: func(string, string) (string, error) { return "rotated-value", nil },
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		env      []string
+		want     []string
+	}{
+		{"recreate reports the rotated secret", SecretReloadRecreate, []string{"LEVEL=info", "URL=original-value"}, []string{"differs for URL"}},
+		{"none keeps the old secret", SecretReloadNone, []string{"LEVEL=info", "URL=original-value"}, nil},
+		{"none still reports the other variables", SecretReloadNone, []string{"LEVEL=debug", "URL=original-value"}, []string{"differs for LEVEL"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req DeploymentRequest
+			req.Metadata.Name = "api"
+			req.Spec.SecretReload.Strategy = test.strategy
+			req.Container.Name = "api"
+			req.Container.Image = "nginx"
+			req.Container.EnvVars = append(req.Container.EnvVars, struct {
+				Name  string `json:"name,omitempty" yaml:"name"`
+				Value string `json:"value,omitempty" yaml:"value"`
+			}{Name: "LEVEL", Value: "info"})
+			req.Container.Secrets = []Secret{{SecretPath: "/Nats", SecretKey: "URL"}}
+			revision := Revision{Request: req}
+
+			inspect := types.ContainerJSON{
+				ContainerJSONBase: &types.ContainerJSONBase{
+					State:      &types.ContainerState{Running: true, Status: "running"},
+					HostConfig: &containertypes.HostConfig{},
+				},
+				Config: &containertypes.Config{
+					Env:    test.env,
+					Labels: map[string]string{LabelConfigHash: configHash(revision)},
+				},
+				NetworkSettings: &types.NetworkSettings{
+					Networks: map[string]*network.EndpointSettings{defaultNetwork: {}},
+				},
+			}
+
+			envVars, err := docker.driftEnv(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			drifts, err := replicaDrift(revision, 0, inspect, envVars, docker.network)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, drift := range drifts {
+				got = append(got, drift.Detail)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("drifts = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
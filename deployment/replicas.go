@@ -0,0 +1,73 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"log"
+	"strconv"
+)
+
+// DeploymentResult describes the containers created by DeployContainer
+type DeploymentResult struct {
+	ContainerIDs []string
+	ImageDigest  string
+	// Revision is the number of the revision now deployed
+	Revision int
+	// RolledBack is set when the deployment failed and Previous was restored
+	RolledBack bool
+	Previous   *Revision
+}
+
+// deploymentName returns the name used to group the replicas of a request
+func deploymentName(req DeploymentRequest) string {
+	if req.Metadata.Name != "" {
+		return req.Metadata.Name
+	}
+	return req.Container.Name
+}
+
+// replicaCount returns the number of containers to run, at least one
+func replicaCount(req DeploymentRequest) int {
+	if req.Spec.Replicas < 1 {
+		return 1
+	}
+	return req.Spec.Replicas
+}
+
+// replicaName returns the deterministic container name of a replica.
+// The first replica keeps Container.Name so single-replica deployments are unchanged.
+func replicaName(req DeploymentRequest, index int) string {
+	if index == 0 {
+		return req.Container.Name
+	}
+	return fmt.Sprintf("%s-%d", req.Container.Name, index)
+}
+
+// removeExtraReplicas removes the containers of a deployment whose replica index is
+// beyond the requested replica count
+func (docker *dockerCmd) removeExtraReplicas(ctx context.Context, req DeploymentRequest) error {
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("label", LabelDeployment+"="+deploymentName(req))
+
+	containers, err := docker.cli.ContainerList(ctx, containertypes.ListOptions{All: true, Filters: filterArgs})
+	if err != nil {
+		return err
+	}
+
+	for _, icontainer := range containers {
+		index, err := strconv.Atoi(icontainer.Labels[LabelReplica])
+		if err != nil || index < replicaCount(req) {
+			continue
+		}
+
+		log.Printf("Scaling down: removing replica %v (%v)\n", index, icontainer.ID)
+		docker.removeSecretFiles(ctx, icontainer.ID)
+		if err := docker.cli.ContainerRemove(ctx, icontainer.ID, containertypes.RemoveOptions{Force: true}); err != nil {
+			log.Printf("Error removing container: %v\n", err)
+		}
+	}
+
+	return nil
+}
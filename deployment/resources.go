@@ -0,0 +1,63 @@
+package deployment
+
+import (
+	"fmt"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+	"math/big"
+)
+
+// replicaResources returns the resource limits of the containers of a request
+func replicaResources(req DeploymentRequest) (containertypes.Resources, error) {
+	spec := req.Container.Resources
+	resources := containertypes.Resources{
+		CPUShares:  spec.CPUShares,
+		CpusetCpus: spec.CpusetCpus,
+		PidsLimit:  spec.PidsLimit,
+	}
+
+	var err error
+	if spec.Memory != "" {
+		if resources.Memory, err = units.RAMInBytes(spec.Memory); err != nil {
+			return resources, fmt.Errorf("invalid memory %q: %w", spec.Memory, err)
+		}
+	}
+	if spec.MemoryReservation != "" {
+		if resources.MemoryReservation, err = units.RAMInBytes(spec.MemoryReservation); err != nil {
+			return resources, fmt.Errorf("invalid memoryReservation %q: %w", spec.MemoryReservation, err)
+		}
+	}
+	if resources.Memory > 0 && resources.MemoryReservation > resources.Memory {
+		return resources, fmt.Errorf("memoryReservation %v is above the memory limit %v", spec.MemoryReservation, spec.Memory)
+	}
+
+	if spec.CPUs != "" {
+		if resources.NanoCPUs, err = nanoCPUs(spec.CPUs); err != nil {
+			return resources, err
+		}
+	}
+
+	for _, ulimit := range spec.Ulimits {
+		if ulimit.Name == "" || ulimit.Soft > ulimit.Hard {
+			return resources, fmt.Errorf("invalid ulimit %v=%v:%v", ulimit.Name, ulimit.Soft, ulimit.Hard)
+		}
+		resources.Ulimits = append(resources.Ulimits, &units.Ulimit{Name: ulimit.Name, Soft: ulimit.Soft, Hard: ulimit.Hard})
+	}
+
+	return resources, nil
+}
+
+// nanoCPUs converts a number of CPUs such as "1.5" to billionths of a CPU
+func nanoCPUs(cpus string) (int64, error) {
+	value, ok := new(big.Rat).SetString(cpus)
+	if !ok || value.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid cpus %q", cpus)
+	}
+
+	nano := value.Mul(value, big.NewRat(1e9, 1))
+	if !nano.IsInt() {
+		return 0, fmt.Errorf("cpus %q has too many decimals", cpus)
+	}
+
+	return nano.Num().Int64(), nil
+}
@@ -0,0 +1,88 @@
+package deployment
+
+import (
+	"DeploymentManager/utils"
+	"context"
+	"fmt"
+	"log"
+)
+
+// previousRevision returns the revision currently running for a request, nil if none
+func (docker *dockerCmd) previousRevision(ctx context.Context, req DeploymentRequest) *Revision {
+	current, err := docker.store.Current(deploymentName(req))
+	if err != nil {
+		log.Printf("Error reading the current revision of %v: %v\n", deploymentName(req), err)
+	}
+	if current != nil {
+		return current
+	}
+
+	// Deployed before the store existed
+	inspect, err := docker.cli.ContainerInspect(ctx, replicaName(req, 0))
+	if err != nil {
+		return nil
+	}
+
+	revision := &Revision{ImageDigest: inspect.Image}
+
+	err = utils.ReadFromFile(inspect.ID+".gob", &revision.Request)
+	if err != nil {
+		log.Printf("No stored request for container %v, a rollback would reuse the new request with image %v\n", inspect.ID, inspect.Image)
+		revision.Request = req
+	}
+	forgetSecretValues(&revision.Request)
+
+	return revision
+}
+
+// imageDigest returns the content addressable ID of a local image
+func (docker *dockerCmd) imageDigest(ctx context.Context, imageName string) (string, error) {
+	inspect, _, err := docker.cli.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return "", err
+	}
+
+	return inspect.ID, nil
+}
+
+// rollback restores the previous revision after a failed rollout. The previous image
+// is pinned by digest so a moved tag cannot be deployed instead.
+func (docker *dockerCmd) rollback(ctx context.Context, revision *Revision, previous *Revision, failed *DeploymentResult, cause error) (*DeploymentResult, error) {
+	if previous == nil {
+		log.Println("No previous revision, removing the containers of the failed deployment")
+		for _, containerId := range failed.ContainerIDs {
+			docker.removeContainer(ctx, containerId)
+		}
+		return &DeploymentResult{}, fmt.Errorf("deployment failed with no previous revision to roll back to: %w", cause)
+	}
+
+	log.Printf("Rolling back %v to image %v\n", deploymentName(previous.Request), previous.ImageDigest)
+
+	// The restored containers are labelled with the revision following the failed one
+	restoredRevision := *previous
+	restoredRevision.Number = revision.Number + 1
+	restoredRevision.EventID = revision.EventID
+	template, err := docker.newReplicaTemplate(restoredRevision, true)
+	if err != nil {
+		return &DeploymentResult{}, fmt.Errorf("deployment failed: %v; rollback to %v failed: %w", cause, previous.ImageDigest, err)
+	}
+
+	restored, err := docker.rollout(ctx, template)
+	if err != nil {
+		return restored, fmt.Errorf("deployment failed: %v; rollback to %v failed: %w", cause, previous.ImageDigest, err)
+	}
+
+	// Replicas added by the failed deployment, or running under another name
+	if err := docker.removeExtraReplicas(ctx, template.req); err != nil {
+		log.Printf("Error scaling down replicas: %v\n", err)
+	}
+	for _, containerId := range failed.ContainerIDs {
+		docker.removeContainer(ctx, containerId)
+	}
+
+	restored.ImageDigest = previous.ImageDigest
+	restored.RolledBack = true
+	restored.Previous = previous
+
+	return restored, fmt.Errorf("deployment failed and was rolled back to %v: %w", previous.ImageDigest, cause)
+}
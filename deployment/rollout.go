@@ -0,0 +1,273 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxSurge        = 1
+	defaultMinReadySeconds = 3
+
+	// Suffixes of the temporary names used while a replica is being replaced
+	nextSuffix     = "-next"
+	previousSuffix = "-previous"
+)
+
+// rolloutStrategy returns the strategy of a request with its defaults applied
+func rolloutStrategy(req DeploymentRequest) (maxSurge int, maxUnavailable int, minReady time.Duration) {
+	maxSurge = defaultMaxSurge
+	if req.Spec.Strategy.MaxSurge != nil {
+		maxSurge = max(*req.Spec.Strategy.MaxSurge, 0)
+	}
+
+	maxUnavailable = max(req.Spec.Strategy.MaxUnavailable, 0)
+
+	// At least one replica must be replaced at a time
+	if maxSurge == 0 && maxUnavailable == 0 {
+		maxSurge = 1
+	}
+
+	minReadySeconds := defaultMinReadySeconds
+	if req.Spec.Strategy.MinReadySeconds != nil {
+		minReadySeconds = max(*req.Spec.Strategy.MinReadySeconds, 0)
+	}
+
+	return maxSurge, maxUnavailable, time.Duration(minReadySeconds) * time.Second
+}
+
+// rollout replaces every replica of a deployment in batches of maxSurge + maxUnavailable.
+// In each batch up to maxUnavailable replicas are stopped before their replacement starts,
+// the others are replaced start-first. The rollout stops at the first failed batch.
+func (docker *dockerCmd) rollout(ctx context.Context, template replicaTemplate) (*DeploymentResult, error) {
+	req := template.req
+	maxSurge, maxUnavailable, minReady := rolloutStrategy(req)
+	count := replicaCount(req)
+	batchSize := maxSurge + maxUnavailable
+
+	if err := docker.ensureVolumes(ctx, req); err != nil {
+		return &DeploymentResult{}, err
+	}
+	if err := docker.ensureNetworks(ctx, req); err != nil {
+		return &DeploymentResult{}, err
+	}
+
+	log.Printf("Rolling out %v replicas of %v (maxSurge %v, maxUnavailable %v)\n", count, deploymentName(req), maxSurge, maxUnavailable)
+
+	containerIds := make([]string, count)
+	result := &DeploymentResult{}
+
+	for start := 0; start < count; start += batchSize {
+		end := min(start+batchSize, count)
+		errs := make([]error, end-start)
+
+		var wg sync.WaitGroup
+		wg.Add(end - start)
+
+		for index := start; index < end; index++ {
+			stopFirst := index-start < maxUnavailable
+
+			go func(index int, stopFirst bool) {
+				defer wg.Done()
+				containerIds[index], errs[index-start] = docker.replaceReplica(ctx, template, index, stopFirst, minReady)
+			}(index, stopFirst)
+		}
+		wg.Wait()
+
+		for _, containerId := range containerIds[start:end] {
+			if containerId != "" {
+				result.ContainerIDs = append(result.ContainerIDs, containerId)
+			}
+		}
+
+		if err := errors.Join(errs...); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// replaceReplica deploys a single replica and retires the container it replaces.
+// Start-first: the new container runs under a temporary name and is renamed once the
+// old one is retired. Stop-first: the old container is stopped and kept aside until
+// the new one is verified, and restarted if it is not.
+func (docker *dockerCmd) replaceReplica(ctx context.Context, template replicaTemplate, index int, stopFirst bool, minReady time.Duration) (string, error) {
+	req := template.req
+	name := replicaName(req, index)
+
+	// Leftovers of an interrupted rollout
+	docker.removeContainer(ctx, name+nextSuffix)
+
+	old, err := docker.cli.ContainerInspect(ctx, name)
+	if errdefs.IsNotFound(err) {
+		containerId, err := docker.createReplica(ctx, template, index, name)
+		if err == nil {
+			err = docker.verifyReplica(ctx, req, containerId, minReady)
+		}
+		if err != nil {
+			docker.removeContainer(ctx, containerId)
+			return "", fmt.Errorf("replica %v: %w", name, err)
+		}
+
+		log.Printf("Replica %v created: %v\n", name, containerId)
+		return containerId, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Two containers cannot bind the same host port or hold the same address
+	bindsHost, err := bindsHostPorts(req, index)
+	if err != nil {
+		return "", err
+	}
+	if (bindsHost || usesStaticIP(req)) && !stopFirst {
+		log.Printf("Replica %v binds host ports or static addresses, stopping it before its replacement starts\n", name)
+		stopFirst = true
+	}
+
+	if stopFirst {
+		return docker.replaceStopFirst(ctx, template, index, old.ID, minReady)
+	}
+
+	return docker.replaceStartFirst(ctx, template, index, old.ID, minReady)
+}
+
+func (docker *dockerCmd) replaceStartFirst(ctx context.Context, template replicaTemplate, index int, oldId string, minReady time.Duration) (string, error) {
+	req := template.req
+	name := replicaName(req, index)
+
+	containerId, err := docker.createReplica(ctx, template, index, name+nextSuffix)
+	if err == nil {
+		err = docker.verifyReplica(ctx, req, containerId, minReady)
+	}
+	if err != nil {
+		docker.removeContainer(ctx, containerId)
+		return "", fmt.Errorf("replica %v: %w", name, err)
+	}
+
+	if err := docker.retireContainer(ctx, oldId); err != nil {
+		return containerId, fmt.Errorf("replica %v: error retiring old container: %w", name, err)
+	}
+
+	if err := docker.cli.ContainerRename(ctx, containerId, name); err != nil {
+		return containerId, fmt.Errorf("replica %v: error renaming container: %w", name, err)
+	}
+
+	log.Printf("Replica %v replaced: %v -> %v\n", name, oldId, containerId)
+	return containerId, nil
+}
+
+func (docker *dockerCmd) replaceStopFirst(ctx context.Context, template replicaTemplate, index int, oldId string, minReady time.Duration) (string, error) {
+	req := template.req
+	name := replicaName(req, index)
+
+	// Set the old container aside so its name can be reused
+	docker.removeContainer(ctx, name+previousSuffix)
+
+	if err := docker.cli.ContainerStop(ctx, oldId, containertypes.StopOptions{}); err != nil {
+		return "", fmt.Errorf("replica %v: error stopping old container: %w", name, err)
+	}
+
+	if err := docker.cli.ContainerRename(ctx, oldId, name+previousSuffix); err != nil {
+		docker.restoreContainer(ctx, oldId, name)
+		return "", fmt.Errorf("replica %v: error renaming old container: %w", name, err)
+	}
+
+	containerId, err := docker.createReplica(ctx, template, index, name)
+	if err == nil {
+		err = docker.verifyReplica(ctx, req, containerId, minReady)
+	}
+	if err != nil {
+		docker.removeContainer(ctx, containerId)
+		docker.restoreContainer(ctx, oldId, name)
+		return "", fmt.Errorf("replica %v: %w", name, err)
+	}
+
+	docker.removeContainer(ctx, oldId)
+
+	log.Printf("Replica %v replaced: %v -> %v\n", name, oldId, containerId)
+	return containerId, nil
+}
+
+// verifyReplica waits for the readiness probe, then for minReady and checks the container
+// is still running without restarts
+func (docker *dockerCmd) verifyReplica(ctx context.Context, req DeploymentRequest, containerId string, minReady time.Duration) error {
+	if req.Container.ReadinessProbe != nil {
+		if err := docker.waitReady(ctx, containerId, req.Container.ReadinessProbe); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(minReady):
+	}
+
+	inspect, err := docker.cli.ContainerInspect(ctx, containerId)
+	if err != nil {
+		return err
+	}
+
+	if inspect.State.OOMKilled {
+		return fmt.Errorf("container was killed for exceeding its memory limit of %v", req.Container.Resources.Memory)
+	}
+
+	if !inspect.State.Running {
+		return fmt.Errorf("container is %v (exit code %v) %v", inspect.State.Status, inspect.State.ExitCode, inspect.State.Error)
+	}
+
+	if inspect.RestartCount > 0 {
+		return fmt.Errorf("container restarted %v times", inspect.RestartCount)
+	}
+
+	if inspect.State.Health != nil && inspect.State.Health.Status == types.Unhealthy {
+		return errors.New("container is unhealthy")
+	}
+
+	return nil
+}
+
+// retireContainer gracefully stops then removes a container
+func (docker *dockerCmd) retireContainer(ctx context.Context, containerId string) error {
+	if err := docker.cli.ContainerStop(ctx, containerId, containertypes.StopOptions{}); err != nil {
+		return err
+	}
+
+	docker.removeSecretFiles(ctx, containerId)
+
+	return docker.cli.ContainerRemove(ctx, containerId, containertypes.RemoveOptions{})
+}
+
+// restoreContainer renames a container set aside back to its name and starts it again
+func (docker *dockerCmd) restoreContainer(ctx context.Context, containerId string, name string) {
+	if err := docker.cli.ContainerRename(ctx, containerId, name); err != nil {
+		log.Printf("Error restoring container name %v: %v\n", name, err)
+	}
+
+	if err := docker.cli.ContainerStart(ctx, containerId, containertypes.StartOptions{}); err != nil {
+		log.Printf("Error restarting container %v: %v\n", name, err)
+	}
+}
+
+// removeContainer force removes a container if it exists
+func (docker *dockerCmd) removeContainer(ctx context.Context, containerId string) {
+	if containerId == "" {
+		return
+	}
+
+	docker.removeSecretFiles(ctx, containerId)
+	err := docker.cli.ContainerRemove(ctx, containerId, containertypes.RemoveOptions{Force: true})
+	if err != nil && !errdefs.IsNotFound(err) {
+		log.Printf("Error removing container %v: %v\n", containerId, err)
+	}
+}
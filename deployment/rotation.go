@@ -0,0 +1,108 @@
+package deployment
+
+import (
+	"DeploymentManager/cache"
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// Strategies reloading the secrets of a deployment
+const (
+	// SecretReloadRecreate deploys the current revision again
+	SecretReloadRecreate = "recreate"
+	// SecretReloadFiles updates the secret files of the containers
+	SecretReloadFiles = "files"
+	// SecretReloadSignal updates the secret files then signals the containers
+	SecretReloadSignal = "signal"
+	// SecretReloadNone leaves the containers with their old secrets
+	SecretReloadNone = "none"
+)
+
+const (
+	// Number of deployments rolled at once on secret rotation by default
+	defaultRotationConcurrency = 4
+	defaultReloadSignal        = "SIGHUP"
+)
+
+// RotationResult is the outcome of a deployment rolled for a secret rotation
+type RotationResult struct {
+	// Revision is the revision deployed again
+	Revision Revision
+	// Strategy is the secret reload strategy applied
+	Strategy string
+	// Result is set when the deployment was recreated
+	Result *DeploymentResult
+	Err    error
+}
+
+// secretReloadStrategy returns the secret reload strategy of a request. Deployments with
+// secrets in their environment are recreated by default, the others get their files updated.
+func secretReloadStrategy(req DeploymentRequest) string {
+	if strategy := req.Spec.SecretReload.Strategy; strategy != "" {
+		return strings.ToLower(strategy)
+	}
+
+	if hasEnvSecrets(req) || len(secretFileDirs(req)) == 0 {
+		return SecretReloadRecreate
+	}
+	return SecretReloadFiles
+}
+
+// reloadSignal returns the signal sent by the signal strategy
+func reloadSignal(req DeploymentRequest) string {
+	return cmp.Or(req.Spec.SecretReload.Signal, defaultReloadSignal)
+}
+
+// secretIndex maps every secret to the revisions referencing it
+type secretIndex struct {
+	revisions []Revision
+	// secrets holds the indexes in revisions of every secret, keyed by cache.Key
+	secrets map[string][]int
+}
+
+// newSecretIndex indexes the secrets of the requests of revisions
+func newSecretIndex(revisions []Revision) secretIndex {
+	index := secretIndex{revisions: revisions, secrets: map[string][]int{}}
+
+	for i, revision := range revisions {
+		for _, secret := range revision.Request.Container.Secrets {
+			ref := cache.Key(secret.SecretPath, secret.SecretKey)
+			if !slices.Contains(index.secrets[ref], i) {
+				index.secrets[ref] = append(index.secrets[ref], i)
+			}
+		}
+	}
+
+	return index
+}
+
+// affected returns the revisions referencing a secret, every secret of the folder
+// secretPath when secretKey is empty, and every secret when both are empty
+func (index secretIndex) affected(secretPath string, secretKey string) []Revision {
+	var found []int
+	for ref, indexes := range index.secrets {
+		switch {
+		case secretPath == "" && secretKey == "":
+		case secretKey == "" && strings.HasPrefix(ref, cache.Key(secretPath, "")):
+		case ref == cache.Key(secretPath, secretKey):
+		default:
+			continue
+		}
+
+		for _, i := range indexes {
+			if !slices.Contains(found, i) {
+				found = append(found, i)
+			}
+		}
+	}
+
+	// In the order the deployments were found
+	slices.Sort(found)
+
+	var revisions []Revision
+	for _, i := range found {
+		revisions = append(revisions, index.revisions[i])
+	}
+	return revisions
+}
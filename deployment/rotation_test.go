@@ -0,0 +1,55 @@
+package deployment
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSecretIndexAffected(t *testing.T) {
+	revision := func(name string, secrets ...Secret) Revision {
+		var req DeploymentRequest
+		req.Metadata.Name = name
+		req.Container.Secrets = secrets
+		return Revision{Request: req}
+	}
+
+	index := newSecretIndex([]Revision{
+		revision("api", Secret{SecretPath: "/Nats", SecretKey: "URL"}, Secret{SecretPath: "/Db", SecretKey: "PASSWORD"}),
+		revision("worker", Secret{SecretPath: "/Nats/", SecretKey: "CREDS"}),
+		revision("admin", Secret{SecretPath: "/NatsAdmin", SecretKey: "URL"}),
+		revision("events", Secret{SecretPath: "/Nats/Events", SecretKey: "URL"}),
+		revision("web"),
+	})
+
+	tests := []struct {
+		name       string
+		secretPath string
+		secretKey  string
+		want       []string
+	}{
+		{"key", "/Nats", "URL", []string{"api"}},
+		{"key with trailing slash", "/Nats/", "URL", []string{"api"}},
+		{"key stored with trailing slash", "/Nats", "CREDS", []string{"worker"}},
+		{"key without leading slash", "Db", "PASSWORD", []string{"api"}},
+		{"folder", "/Nats", "", []string{"api", "worker"}},
+		{"folder with trailing slash", "/Nats/", "", []string{"api", "worker"}},
+		{"folder is not a prefix of its siblings", "/NatsAdmin", "", []string{"admin"}},
+		{"subfolder", "/Nats/Events", "", []string{"events"}},
+		{"every secret", "", "", []string{"api", "worker", "admin", "events"}},
+		{"unknown key", "/Nats", "PASSWORD", nil},
+		{"unknown folder", "/Redis", "", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, revision := range index.affected(test.secretPath, test.secretKey) {
+				got = append(got, revision.Request.Metadata.Name)
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("affected(%q, %q) = %v, want %v", test.secretPath, test.secretKey, got, test.want)
+			}
+		})
+	}
+}
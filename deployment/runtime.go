@@ -0,0 +1,34 @@
+package deployment
+
+import (
+	"fmt"
+	containertypes "github.com/docker/docker/api/types/container"
+)
+
+// restartPolicy returns the restart policy of the containers of a request, always by default.
+// Jobs are restarted on failure up to their backoff limit.
+func restartPolicy(req DeploymentRequest) (containertypes.RestartPolicy, error) {
+	if isJob(req) {
+		if req.Container.RestartPolicy.Name != "" {
+			return containertypes.RestartPolicy{}, fmt.Errorf("restartPolicy is not supported by jobs, use spec.backoffLimit")
+		}
+		if req.Spec.BackoffLimit == 0 {
+			return containertypes.RestartPolicy{Name: containertypes.RestartPolicyDisabled}, nil
+		}
+		return containertypes.RestartPolicy{Name: containertypes.RestartPolicyOnFailure, MaximumRetryCount: req.Spec.BackoffLimit}, nil
+	}
+
+	policy := containertypes.RestartPolicy{
+		Name:              containertypes.RestartPolicyMode(req.Container.RestartPolicy.Name),
+		MaximumRetryCount: req.Container.RestartPolicy.MaximumRetryCount,
+	}
+	if policy.Name == "" {
+		policy.Name = containertypes.RestartPolicyAlways
+	}
+
+	if err := containertypes.ValidateRestartPolicy(policy); err != nil {
+		return policy, fmt.Errorf("invalid restartPolicy: %w", err)
+	}
+
+	return policy, nil
+}
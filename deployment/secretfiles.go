@@ -0,0 +1,345 @@
+package deployment
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/errdefs"
+	"github.com/google/uuid"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Delivery modes of a secret
+const (
+	SecretDeliveryEnv  = "env"
+	SecretDeliveryFile = "file"
+)
+
+const (
+	// secretsDir is where the secret files are written in the container by default
+	secretsDir = "/run/secrets"
+	// defaultSecretFilesDir is the directory of the host, on a tmpfs, holding the secret files.
+	// It is mounted at the same path in the manager, which checks and cleans it up.
+	defaultSecretFilesDir = "/run/bluerobin/secrets"
+	defaultSecretFileMode = 0o400
+)
+
+// secretFile is a resolved secret delivered as a file
+type secretFile struct {
+	Path  string
+	Mode  int64
+	UID   int
+	GID   int
+	Value string
+}
+
+// isFileSecret returns true when a secret is written to a file rather than the environment
+func isFileSecret(secret Secret) bool {
+	return strings.ToLower(secret.Delivery) == SecretDeliveryFile
+}
+
+// secretFilePath returns the path of the file of a secret in the container, which is always
+// under /run/secrets so that the mounts never hide files of the image
+func secretFilePath(secret Secret) string {
+	return path.Join(secretsDir, path.Clean("/"+cmp.Or(secret.Path, secretEnvName(secret))))
+}
+
+// secretFileMode parses the octal mode of the file of a secret
+func secretFileMode(secret Secret) (int64, error) {
+	if secret.Mode == "" {
+		return defaultSecretFileMode, nil
+	}
+
+	mode, err := strconv.ParseUint(secret.Mode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid file mode %q, expected octal permissions such as 0440", secret.Mode)
+	}
+	return int64(mode), nil
+}
+
+// secretFileOwner parses the numeric uid[:gid] owning the file of a secret, the gid
+// defaults to the uid
+func secretFileOwner(secret Secret) (int, int, error) {
+	if secret.Owner == "" {
+		return 0, 0, nil
+	}
+
+	user, group, hasGroup := strings.Cut(secret.Owner, ":")
+	uid, err := strconv.Atoi(user)
+	if err != nil || uid < 0 {
+		return 0, 0, fmt.Errorf("invalid owner %q, expected a numeric uid[:gid]", secret.Owner)
+	}
+	if !hasGroup {
+		return uid, uid, nil
+	}
+
+	gid, err := strconv.Atoi(group)
+	if err != nil || gid < 0 {
+		return 0, 0, fmt.Errorf("invalid owner %q, expected a numeric uid[:gid]", secret.Owner)
+	}
+	return uid, gid, nil
+}
+
+// secretFileDirs returns the directories holding the secret files of a request
+func secretFileDirs(req DeploymentRequest) []string {
+	var dirs []string
+	for _, secret := range req.Container.Secrets {
+		if !isFileSecret(secret) {
+			continue
+		}
+		if dir := path.Dir(secretFilePath(secret)); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	slices.Sort(dirs)
+	return dirs
+}
+
+// secretMounts returns the mounts of the directories of the secret files of a replica.
+// Each replica gets its own directories on the host tmpfs so the files can be written
+// before the container starts, and are kept when it restarts.
+func (docker *dockerCmd) secretMounts(req DeploymentRequest) ([]mount.Mount, error) {
+	dirs := secretFileDirs(req)
+	if len(dirs) == 0 {
+		return nil, nil
+	}
+	if docker.fileSecretsErr != nil {
+		return nil, docker.fileSecretsErr
+	}
+
+	replicaDir := path.Join(docker.secretFilesDir, uuid.NewString())
+
+	var mounts []mount.Mount
+	for i, dir := range dirs {
+		mounts = append(mounts, mount.Mount{
+			Type:        mount.TypeBind,
+			Source:      path.Join(replicaDir, strconv.Itoa(i)),
+			Target:      dir,
+			BindOptions: &mount.BindOptions{CreateMountpoint: true},
+		})
+	}
+
+	return mounts, nil
+}
+
+// secretReplicaDir returns the directory of the replica owning the source of a mount, if it
+// is under the secret files directory
+func (docker *dockerCmd) secretReplicaDir(source string) (string, bool) {
+	relative, ok := strings.CutPrefix(path.Clean(source), path.Clean(docker.secretFilesDir)+"/")
+	if !ok {
+		return "", false
+	}
+
+	replica, _, _ := strings.Cut(relative, "/")
+	return path.Join(docker.secretFilesDir, replica), true
+}
+
+// containerSecretFiles resolves the secrets of a request delivered as files. Every secret
+// that cannot be resolved is reported.
+func (docker *dockerCmd) containerSecretFiles(req DeploymentRequest) ([]secretFile, error) {
+	var files []secretFile
+
+	unresolved := &UnresolvedSecretsError{}
+	for _, secret := range req.Container.Secrets {
+		if !isFileSecret(secret) {
+			continue
+		}
+
+		value, err := docker.resolveSecret(secret)
+		if err != nil {
+			unresolved.Secrets = append(unresolved.Secrets, fmt.Sprintf("%v (%v)", secretName(secret), err))
+			continue
+		}
+
+		mode, err := secretFileMode(secret)
+		if err != nil {
+			return nil, err
+		}
+		uid, gid, err := secretFileOwner(secret)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, secretFile{Path: secretFilePath(secret), Mode: mode, UID: uid, GID: gid, Value: value})
+	}
+
+	if len(unresolved.Secrets) > 0 {
+		return nil, unresolved
+	}
+
+	return files, nil
+}
+
+// writeSecretFiles copies the secret files into a container, one archive per directory.
+// Existing files are replaced, so it also updates the secrets of a running container.
+func (docker *dockerCmd) writeSecretFiles(ctx context.Context, containerId string, files []secretFile) error {
+	dirs := map[string][]secretFile{}
+	for _, file := range files {
+		dirs[path.Dir(file.Path)] = append(dirs[path.Dir(file.Path)], file)
+	}
+
+	for dir, dirFiles := range dirs {
+		var archive bytes.Buffer
+		writer := tar.NewWriter(&archive)
+
+		for _, file := range dirFiles {
+			header := &tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     path.Base(file.Path),
+				Mode:     file.Mode,
+				Uid:      file.UID,
+				Gid:      file.GID,
+				Size:     int64(len(file.Value)),
+				ModTime:  time.Now(),
+			}
+			if err := writer.WriteHeader(header); err != nil {
+				return err
+			}
+			if _, err := writer.Write([]byte(file.Value)); err != nil {
+				return err
+			}
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+
+		if err := docker.cli.CopyToContainer(ctx, containerId, dir, &archive, containertypes.CopyToContainerOptions{}); err != nil {
+			return fmt.Errorf("error writing the secret files of %v: %w", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// removeSecretFiles removes the secret files of a container before it is removed, the
+// host directories are not removed with the container
+func (docker *dockerCmd) removeSecretFiles(ctx context.Context, containerId string) {
+	inspect, err := docker.cli.ContainerInspect(ctx, containerId)
+	if err != nil {
+		if !errdefs.IsNotFound(err) {
+			log.Printf("Error inspecting container %v: %v\n", containerId, err)
+		}
+		return
+	}
+
+	for _, m := range inspect.Mounts {
+		replicaDir, ok := docker.secretReplicaDir(m.Source)
+		if !ok {
+			continue
+		}
+		if err := os.RemoveAll(replicaDir); err != nil {
+			log.Printf("Error removing the secret files of container %v: %v\n", containerId, err)
+		}
+	}
+}
+
+// pruneSecretFiles removes the secret files of the containers that no longer exist
+func (docker *dockerCmd) pruneSecretFiles(ctx context.Context) error {
+	if docker.fileSecretsErr != nil {
+		return nil
+	}
+
+	containers, err := docker.cli.ContainerList(ctx, containertypes.ListOptions{All: true})
+	if err != nil {
+		return err
+	}
+
+	used := map[string]bool{}
+	for _, container := range containers {
+		for _, m := range container.Mounts {
+			if replicaDir, ok := docker.secretReplicaDir(m.Source); ok {
+				used[replicaDir] = true
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(docker.secretFilesDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		replicaDir := path.Join(docker.secretFilesDir, entry.Name())
+		if used[replicaDir] {
+			continue
+		}
+
+		log.Printf("Removing the secret files left by a removed container: %v\n", replicaDir)
+		if err := os.RemoveAll(replicaDir); err != nil {
+			log.Printf("Error removing %v: %v\n", replicaDir, err)
+		}
+	}
+
+	return nil
+}
+
+// hasEnvSecrets returns true when a request injects secrets in the environment, they can
+// only be changed by recreating its containers
+func hasEnvSecrets(req DeploymentRequest) bool {
+	for _, secret := range req.Container.Secrets {
+		if !isFileSecret(secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateSecretFiles writes the current value of the file secrets into every container of a
+// deployment, without recreating them, then sends signal to the running ones if set
+func (docker *dockerCmd) updateSecretFiles(ctx context.Context, name string, signal string) error {
+	lock := docker.deploymentLock(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("label", LabelDeployment+"="+name)
+	containers, err := docker.cli.ContainerList(ctx, containertypes.ListOptions{All: true, Filters: filterArgs})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, container := range containers {
+		// Every container is updated with the secrets of the request it was created from
+		var req DeploymentRequest
+		if err := json.Unmarshal([]byte(container.Labels[LabelRequest]), &req); err != nil {
+			continue
+		}
+
+		files, err := docker.containerSecretFiles(req)
+		if err == nil {
+			err = docker.writeSecretFiles(ctx, container.ID, files)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("container %v: %w", container.ID, err))
+			continue
+		}
+
+		log.Printf("Updated the secret files of container %v\n", container.ID)
+
+		if signal == "" || container.State != "running" {
+			continue
+		}
+		if err := docker.cli.ContainerKill(ctx, container.ID, signal); err != nil {
+			errs = append(errs, fmt.Errorf("container %v: error sending %v: %w", container.ID, signal, err))
+			continue
+		}
+		log.Printf("Sent %v to container %v\n", signal, container.ID)
+	}
+
+	return errors.Join(errs...)
+}
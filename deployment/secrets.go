@@ -0,0 +1,116 @@
+package deployment
+
+import (
+	"DeploymentManager/redact"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SecretResolver returns the value of the secret secretKey in the folder secretPath
+type SecretResolver func(secretPath string, secretKey string) (string, error)
+
+// secretEnvName returns the environment variable a secret is injected as, or the name of
+// its file
+func secretEnvName(secret Secret) string {
+	if secret.Name != "" {
+		return secret.Name
+	}
+	return secret.SecretKey
+}
+
+// UnresolvedSecretsError lists every secret of a request that could not be resolved
+type UnresolvedSecretsError struct {
+	Secrets []string
+}
+
+func (unresolved *UnresolvedSecretsError) Error() string {
+	return "unresolved secrets: " + strings.Join(unresolved.Secrets, ", ")
+}
+
+// resolveSecret returns the value of a secret of a request
+func (docker *dockerCmd) resolveSecret(secret Secret) (string, error) {
+	if docker.secrets == nil {
+		return "", errors.New("no secret manager configured")
+	}
+
+	value, err := docker.secrets(secret.SecretPath, secret.SecretKey)
+	if err != nil {
+		return "", err
+	}
+
+	// The value is masked wherever it would be logged or published
+	redact.Add(value)
+	return value, nil
+}
+
+// containerEnv returns the environment variables of a request, with its secrets resolved
+// through the secret manager. Secrets delivered as files are left out. Every secret that
+// cannot be resolved is reported.
+func (docker *dockerCmd) containerEnv(req DeploymentRequest) ([]string, error) {
+	envVars := plainEnv(req)
+
+	unresolved := &UnresolvedSecretsError{}
+	for _, secret := range req.Container.Secrets {
+		if isFileSecret(secret) {
+			continue
+		}
+
+		value, err := docker.resolveSecret(secret)
+		if err != nil {
+			unresolved.Secrets = append(unresolved.Secrets, fmt.Sprintf("%v (%v)", secretName(secret), err))
+			continue
+		}
+		envVars = append(envVars, secretEnvName(secret)+"="+value)
+	}
+
+	if len(unresolved.Secrets) > 0 {
+		return nil, unresolved
+	}
+
+	return envVars, nil
+}
+
+// plainEnv returns the environment variables of a request that are not secrets
+func plainEnv(req DeploymentRequest) []string {
+	var envVars []string
+	for _, envVar := range req.Container.EnvVars {
+		envVars = append(envVars, envVar.Name+"="+envVar.Value)
+	}
+	return envVars
+}
+
+// secretName identifies a secret in errors, its value is never included
+func secretName(secret Secret) string {
+	return strings.TrimSuffix(secret.SecretPath, "/") + "/" + secret.SecretKey
+}
+
+// redactedEnv returns the environment variables of a request with the values of its
+// secrets masked, to be logged
+func redactedEnv(req DeploymentRequest, envVars []string) []string {
+	secretNames := map[string]bool{}
+	for _, secret := range req.Container.Secrets {
+		if !isFileSecret(secret) {
+			secretNames[secretEnvName(secret)] = true
+		}
+	}
+
+	var redacted []string
+	for _, envVar := range envVars {
+		name, _, _ := strings.Cut(envVar, "=")
+		if secretNames[name] {
+			envVar = name + "=" + redact.Mask
+		}
+		redacted = append(redacted, redact.String(envVar))
+	}
+	return redacted
+}
+
+// forgetSecretValues registers the secret values of a request read from a legacy record
+// to be masked, and removes them from the request
+func forgetSecretValues(req *DeploymentRequest) {
+	for i, secret := range req.Container.Secrets {
+		redact.Add(secret.SecretValue)
+		req.Container.Secrets[i].SecretValue = ""
+	}
+}
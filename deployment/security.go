@@ -0,0 +1,164 @@
+package deployment
+
+import (
+	"cmp"
+	"fmt"
+	containertypes "github.com/docker/docker/api/types/container"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Capabilities that give a container control over the host, only added when privileged
+// containers are allowed
+var dangerousCapabilities = []string{
+	"ALL", "SYS_ADMIN", "SYS_MODULE", "SYS_PTRACE", "SYS_RAWIO", "SYS_BOOT", "SYS_TIME",
+	"NET_ADMIN", "DAC_READ_SEARCH", "MAC_ADMIN", "MAC_OVERRIDE", "BPF", "PERFMON", "SYSLOG",
+}
+
+// Name of a seccomp profile of the profile directory
+var seccompProfileName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+const unconfined = "unconfined"
+
+// SecurityDefaults apply to every container whose request does not override them
+type SecurityDefaults struct {
+	ReadOnlyRootFilesystem bool
+	NoNewPrivileges        bool
+	CapDrop                []string
+	// SeccompProfile is unconfined or the path of a JSON profile
+	SeccompProfile string
+	// SeccompProfileDir holds the JSON profiles requests may name, requests cannot name a
+	// profile when empty
+	SeccompProfileDir string
+	AppArmorProfile   string
+	UsernsMode        string
+	// AllowPrivileged lets requests run privileged containers, add dangerous capabilities,
+	// run unconfined, disable user namespace remapping or bind mount any host path
+	AllowPrivileged bool
+}
+
+// securityConfig sets the security options of a request on the host config of its containers
+func (docker *dockerCmd) securityConfig(req DeploymentRequest, hostConfig *containertypes.HostConfig) error {
+	defaults := docker.security
+	security := req.Container.Security
+
+	capAdd := normalizeCapabilities(security.CapAdd)
+	if !defaults.AllowPrivileged {
+		switch {
+		case security.Privileged:
+			return fmt.Errorf("privileged containers are not allowed")
+		case security.UsernsMode == "host":
+			return fmt.Errorf("usernsMode host is not allowed")
+		case strings.EqualFold(security.SeccompProfile, unconfined):
+			return fmt.Errorf("unconfined seccomp profiles are not allowed")
+		case strings.EqualFold(security.AppArmorProfile, unconfined):
+			return fmt.Errorf("unconfined AppArmor profiles are not allowed")
+		}
+		for _, capability := range capAdd {
+			if slices.Contains(dangerousCapabilities, capability) {
+				return fmt.Errorf("capability %v is not allowed", capability)
+			}
+		}
+	}
+
+	// The capabilities dropped by the manager cannot be added back
+	managerCapDrop := normalizeCapabilities(defaults.CapDrop)
+	for _, capability := range capAdd {
+		if slices.Contains(managerCapDrop, "ALL") || slices.Contains(managerCapDrop, capability) {
+			return fmt.Errorf("capability %v is dropped by the manager", capability)
+		}
+	}
+
+	hostConfig.Privileged = security.Privileged
+	hostConfig.CapAdd = capAdd
+
+	for _, capability := range normalizeCapabilities(slices.Concat(managerCapDrop, security.CapDrop)) {
+		if !slices.Contains(capAdd, capability) && !slices.Contains(hostConfig.CapDrop, capability) {
+			hostConfig.CapDrop = append(hostConfig.CapDrop, capability)
+		}
+	}
+
+	hostConfig.ReadonlyRootfs = defaults.ReadOnlyRootFilesystem
+	if security.ReadOnlyRootFilesystem != nil {
+		hostConfig.ReadonlyRootfs = *security.ReadOnlyRootFilesystem
+	}
+
+	noNewPrivileges := defaults.NoNewPrivileges
+	if security.NoNewPrivileges != nil {
+		noNewPrivileges = *security.NoNewPrivileges
+	}
+	if noNewPrivileges {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "no-new-privileges:true")
+	}
+
+	// Requests name a profile of the profile directory, the default is configured by path
+	var seccompProfile string
+	var err error
+	switch {
+	case security.SeccompProfile != "":
+		seccompProfile, err = requestSeccompOption(security.SeccompProfile, defaults.SeccompProfileDir)
+	case defaults.SeccompProfile != "":
+		seccompProfile, err = seccompOption(defaults.SeccompProfile)
+	}
+	if err != nil {
+		return err
+	}
+	if seccompProfile != "" {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "seccomp="+seccompProfile)
+	}
+
+	if appArmorProfile := cmp.Or(security.AppArmorProfile, defaults.AppArmorProfile); appArmorProfile != "" {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "apparmor="+appArmorProfile)
+	}
+
+	hostConfig.UsernsMode = containertypes.UsernsMode(cmp.Or(security.UsernsMode, defaults.UsernsMode))
+
+	return nil
+}
+
+// normalizeCapabilities trims and upper cases capabilities, strips their CAP_ prefix and
+// drops the empty ones
+func normalizeCapabilities(capabilities []string) []string {
+	var normalized []string
+	for _, capability := range capabilities {
+		capability = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(capability)), "CAP_")
+		if capability != "" {
+			normalized = append(normalized, capability)
+		}
+	}
+	return normalized
+}
+
+// requestSeccompOption returns the seccomp option of a profile named by a request, which
+// must be unconfined or a file of the profile directory
+func requestSeccompOption(name string, profileDir string) (string, error) {
+	if strings.EqualFold(name, unconfined) {
+		return unconfined, nil
+	}
+
+	if profileDir == "" {
+		return "", fmt.Errorf("seccomp profile %q cannot be used, no profile directory is configured", name)
+	}
+	if !seccompProfileName.MatchString(name) || strings.Contains(name, "..") {
+		return "", fmt.Errorf("seccomp profile %q must be the name of a file of the profile directory", name)
+	}
+
+	return seccompOption(filepath.Join(profileDir, name))
+}
+
+// seccompOption returns unconfined as is, the daemon expects the content of other profiles
+func seccompOption(profile string) (string, error) {
+	if strings.EqualFold(profile, unconfined) {
+		return unconfined, nil
+	}
+
+	content, err := os.ReadFile(profile)
+	if err != nil {
+		return "", fmt.Errorf("error reading seccomp profile: %w", err)
+	}
+
+	return string(content), nil
+}
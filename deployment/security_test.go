@@ -0,0 +1,27 @@
+package deployment
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizeCapabilities(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities []string
+		want         []string
+	}{
+		{"upper case", []string{"net_raw"}, []string{"NET_RAW"}},
+		{"CAP_ prefix", []string{"CAP_NET_RAW", "cap_chown"}, []string{"NET_RAW", "CHOWN"}},
+		{"spaces around commas", []string{"ALL", " NET_RAW", "CHOWN "}, []string{"ALL", "NET_RAW", "CHOWN"}},
+		{"empty entries", []string{"", " ", "NET_RAW"}, []string{"NET_RAW"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := normalizeCapabilities(test.capabilities); !slices.Equal(got, test.want) {
+				t.Errorf("normalizeCapabilities(%q) = %q, want %q", test.capabilities, got, test.want)
+			}
+		})
+	}
+}
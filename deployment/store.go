@@ -0,0 +1,357 @@
+package deployment
+
+import (
+	"DeploymentManager/redact"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+// Outcomes of a revision
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	// OutcomeRolledBack is a revision that failed and was replaced by the previous one
+	OutcomeRolledBack = "rolled-back"
+	// OutcomeRestored is the previous revision deployed again by a rollback
+	OutcomeRestored = "restored"
+	// OutcomeRepaired is the current revision deployed again by the reconciler
+	OutcomeRepaired = "repaired"
+	// OutcomeRecovered is a revision rebuilt from the labels of its containers
+	OutcomeRecovered = "recovered"
+	// OutcomeCompleted is a job whose container exited with 0
+	OutcomeCompleted = "completed"
+	// OutcomeRunning is a job that has not exited yet
+	OutcomeRunning = "running"
+)
+
+// Revision is a deployment attempt of a request along with the image it resolved to
+type Revision struct {
+	Number       int
+	Request      DeploymentRequest
+	ImageDigest  string
+	ContainerIDs []string
+	// EventID is the ID of the event that triggered the deployment
+	EventID    string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Outcome    string
+	Error      string
+	// ExitCode and Logs are the result of a job
+	ExitCode *int
+	Logs     string
+}
+
+var (
+	deploymentsBucket = []byte("deployments")
+	cronJobsBucket    = []byte("cronjobs")
+)
+
+// CronJobState is a scheduled request along with the time of its last run
+type CronJobState struct {
+	Request          DeploymentRequest
+	LastScheduleTime time.Time
+}
+
+// Store persists the revisions of every deployment, keyed by deployment name
+type Store struct {
+	db *bolt.DB
+}
+
+// OpenStore opens or creates the state database at path
+func OpenStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening state store %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(deploymentsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(cronJobsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the state database
+func (store *Store) Close() error {
+	return store.db.Close()
+}
+
+// NextRevisionNumber returns the number the next revision of a deployment will get
+func (store *Store) NextRevisionNumber(name string) (int, error) {
+	number := 1
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(deploymentsBucket).Bucket([]byte(name)); bucket != nil {
+			number = int(bucket.Sequence()) + 1
+		}
+		return nil
+	})
+
+	return number, err
+}
+
+// AddRevision appends a revision to the history of its deployment. A revision without a
+// number gets the next one.
+func (store *Store) AddRevision(revision *Revision) error {
+	name := deploymentName(revision.Request)
+	if name == "" {
+		return fmt.Errorf("revision has no deployment name")
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(deploymentsBucket).CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+
+		if revision.Number == 0 {
+			number, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			revision.Number = int(number)
+		} else if uint64(revision.Number) > bucket.Sequence() {
+			if err := bucket.SetSequence(uint64(revision.Number)); err != nil {
+				return err
+			}
+		}
+
+		// Logs and errors may hold secret values
+		stored := *revision
+		stored.Logs = redact.String(stored.Logs)
+		stored.Error = redact.String(stored.Error)
+
+		value, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(revisionKey(uint64(revision.Number)), value)
+	})
+}
+
+// Revisions returns the history of a deployment, oldest first
+func (store *Store) Revisions(name string) ([]Revision, error) {
+	var revisions []Revision
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deploymentsBucket).Bucket([]byte(name))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(_, value []byte) error {
+			var revision Revision
+			if err := json.Unmarshal(value, &revision); err != nil {
+				return err
+			}
+			revisions = append(revisions, revision)
+			return nil
+		})
+	})
+
+	return revisions, err
+}
+
+// Current returns the revision currently deployed for a deployment, nil if none
+func (store *Store) Current(name string) (*Revision, error) {
+	var current *Revision
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deploymentsBucket).Bucket([]byte(name))
+		if bucket == nil {
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+		for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+			var revision Revision
+			if err := json.Unmarshal(value, &revision); err != nil {
+				return err
+			}
+
+			if revision.Outcome == OutcomeSucceeded || revision.Outcome == OutcomeRestored || revision.Outcome == OutcomeRepaired || revision.Outcome == OutcomeRecovered || revision.Outcome == OutcomeCompleted {
+				current = &revision
+				return nil
+			}
+		}
+		return nil
+	})
+
+	return current, err
+}
+
+// Deployments returns the names of every deployment in the store
+func (store *Store) Deployments() ([]string, error) {
+	var names []string
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deploymentsBucket).ForEach(func(key, _ []byte) error {
+			names = append(names, string(key))
+			return nil
+		})
+	})
+
+	return names, err
+}
+
+// CurrentRevisions returns the current revision of every deployment
+func (store *Store) CurrentRevisions() ([]Revision, error) {
+	names, err := store.Deployments()
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []Revision
+	for _, name := range names {
+		current, err := store.Current(name)
+		if err != nil {
+			return nil, err
+		}
+		if current != nil {
+			revisions = append(revisions, *current)
+		}
+	}
+
+	return revisions, nil
+}
+
+// PruneRevisions removes the oldest revisions of a deployment, keeping the last keep ones
+// and those still running
+func (store *Store) PruneRevisions(name string, keep int) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deploymentsBucket).Bucket([]byte(name))
+		if bucket == nil {
+			return nil
+		}
+
+		var stale [][]byte
+		cursor := bucket.Cursor()
+		for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+			if keep > 0 {
+				keep--
+				continue
+			}
+
+			var revision Revision
+			if err := json.Unmarshal(value, &revision); err != nil {
+				return err
+			}
+			if revision.Outcome != OutcomeRunning {
+				stale = append(stale, key)
+			}
+		}
+
+		for _, key := range stale {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FailRunning marks the revisions still running as failed, they cannot finish once the
+// manager restarted
+func (store *Store) FailRunning(reason string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(deploymentsBucket).ForEachBucket(func(name []byte) error {
+			bucket := tx.Bucket(deploymentsBucket).Bucket(name)
+
+			updates := map[string][]byte{}
+			err := bucket.ForEach(func(key, value []byte) error {
+				var revision Revision
+				if err := json.Unmarshal(value, &revision); err != nil {
+					return err
+				}
+				if revision.Outcome != OutcomeRunning {
+					return nil
+				}
+
+				revision.Outcome = OutcomeFailed
+				revision.Error = reason
+				updated, err := json.Marshal(revision)
+				updates[string(key)] = updated
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			for key, value := range updates {
+				if err := bucket.Put([]byte(key), value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// SaveCronJob creates or replaces the state of a cron job
+func (store *Store) SaveCronJob(state CronJobState) error {
+	name := deploymentName(state.Request)
+	if name == "" {
+		return fmt.Errorf("cron job has no name")
+	}
+
+	value, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(cronJobsBucket).Put([]byte(name), value)
+	})
+}
+
+// CronJob returns the state of a cron job, nil if it is not scheduled
+func (store *Store) CronJob(name string) (*CronJobState, error) {
+	var state *CronJobState
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(cronJobsBucket).Get([]byte(name))
+		if value == nil {
+			return nil
+		}
+		state = &CronJobState{}
+		return json.Unmarshal(value, state)
+	})
+
+	return state, err
+}
+
+// CronJobs returns the state of every cron job
+func (store *Store) CronJobs() ([]CronJobState, error) {
+	var states []CronJobState
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(cronJobsBucket).ForEach(func(_, value []byte) error {
+			var state CronJobState
+			if err := json.Unmarshal(value, &state); err != nil {
+				return err
+			}
+			states = append(states, state)
+			return nil
+		})
+	})
+
+	return states, err
+}
+
+func revisionKey(number uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, number)
+	return key
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package deployment
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Filesystem type of a tmpfs reported by statfs
+const tmpfsMagic = 0x01021994
+
+// checkTmpfs returns an error unless dir is on a tmpfs
+func checkTmpfs(dir string) error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return err
+	}
+
+	if stat.Type != tmpfsMagic {
+		return fmt.Errorf("%v is not on a tmpfs", dir)
+	}
+	return nil
+}
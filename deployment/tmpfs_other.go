@@ -0,0 +1,13 @@
+//go:build !linux
+
+package deployment
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// checkTmpfs returns an error, tmpfs are only found on Linux
+func checkTmpfs(dir string) error {
+	return fmt.Errorf("%v cannot be a tmpfs on %v", dir, runtime.GOOS)
+}
@@ -0,0 +1,443 @@
+package deployment
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/distribution/reference"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+	"github.com/moby/sys/signal"
+	"net"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Same rule as the Docker daemon
+var containerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// FieldError is an invalid field of a request, Field is its path such as container.ports[1].hostPort
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (fieldError FieldError) Error() string {
+	return fieldError.Field + ": " + fieldError.Message
+}
+
+// ValidationError lists every invalid field of a request
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (validationError *ValidationError) Error() string {
+	var messages []string
+	for _, fieldError := range validationError.Errors {
+		messages = append(messages, fieldError.Error())
+	}
+	return "invalid request: " + strings.Join(messages, "; ")
+}
+
+func (validationError *ValidationError) add(field string, format string, args ...interface{}) {
+	validationError.Errors = append(validationError.Errors, FieldError{field, fmt.Sprintf(format, args...)})
+}
+
+// Validate checks a request before anything is changed on the host, every invalid field
+// is reported in a *ValidationError
+func (docker *dockerCmd) Validate(req DeploymentRequest) error {
+	invalid := &ValidationError{}
+
+	validateMetadata(req, invalid)
+	validateSpec(req, invalid)
+	validateContainer(req, invalid)
+	validatePorts(req, invalid)
+	docker.validateEnv(req, invalid)
+	validateProbe("container.readinessProbe", req.Container.ReadinessProbe, invalid)
+	validateProbe("container.livenessProbe", req.Container.LivenessProbe, invalid)
+	docker.validateVolumes(req, invalid)
+	validateNetworks(req, invalid)
+
+	if _, err := replicaResources(req); err != nil {
+		invalid.add("container.resources", "%v", err)
+	}
+	if _, err := restartPolicy(req); err != nil {
+		invalid.add("container.restartPolicy", "%v", err)
+	}
+	if err := docker.securityConfig(req, &containertypes.HostConfig{}); err != nil {
+		invalid.add("container.security", "%v", err)
+	}
+
+	if len(invalid.Errors) > 0 {
+		return invalid
+	}
+	return nil
+}
+
+func validateMetadata(req DeploymentRequest, invalid *ValidationError) {
+	switch req.Kind {
+	case "", KindDeployment, KindJob, KindCronJob:
+	default:
+		invalid.add("kind", "unknown kind %q, expected %v, %v or %v", req.Kind, KindDeployment, KindJob, KindCronJob)
+	}
+
+	if req.Metadata.Name != "" && !containerNamePattern.MatchString(req.Metadata.Name) {
+		invalid.add("metadata.name", "%q must match %v", req.Metadata.Name, containerNamePattern)
+	}
+}
+
+func validateSpec(req DeploymentRequest, invalid *ValidationError) {
+	spec := req.Spec
+
+	if spec.Replicas < 0 {
+		invalid.add("spec.replicas", "must not be negative")
+	}
+	if (isJob(req) || isCronJob(req)) && spec.Replicas > 1 {
+		invalid.add("spec.replicas", "a %v runs a single container", req.Kind)
+	}
+
+	if spec.Strategy.MaxSurge != nil && *spec.Strategy.MaxSurge < 0 {
+		invalid.add("spec.strategy.maxSurge", "must not be negative")
+	}
+	if spec.Strategy.MaxUnavailable < 0 {
+		invalid.add("spec.strategy.maxUnavailable", "must not be negative")
+	}
+	if spec.Strategy.MaxSurge != nil && *spec.Strategy.MaxSurge == 0 && spec.Strategy.MaxUnavailable == 0 {
+		invalid.add("spec.strategy", "maxSurge and maxUnavailable cannot both be 0")
+	}
+	if spec.Strategy.MinReadySeconds != nil && *spec.Strategy.MinReadySeconds < 0 {
+		invalid.add("spec.strategy.minReadySeconds", "must not be negative")
+	}
+
+	if spec.BackoffLimit < 0 {
+		invalid.add("spec.backoffLimit", "must not be negative")
+	}
+	if spec.ActiveDeadlineSeconds < 0 {
+		invalid.add("spec.activeDeadlineSeconds", "must not be negative")
+	}
+	if spec.HistoryLimit != nil && *spec.HistoryLimit < 0 {
+		invalid.add("spec.historyLimit", "must not be negative")
+	}
+
+	validateSecretReload(req, invalid)
+
+	if isCronJob(req) {
+		if _, err := cronSchedule(req); err != nil {
+			invalid.add("spec", "%v", err)
+		}
+	} else if spec.Schedule != "" {
+		invalid.add("spec.schedule", "only a %v has a schedule", KindCronJob)
+	}
+}
+
+func validateSecretReload(req DeploymentRequest, invalid *ValidationError) {
+	reload := req.Spec.SecretReload
+
+	switch strings.ToLower(reload.Strategy) {
+	case "", SecretReloadRecreate, SecretReloadFiles, SecretReloadNone:
+		if reload.Signal != "" {
+			invalid.add("spec.secretReload.signal", "only the %v strategy sends a signal", SecretReloadSignal)
+		}
+	case SecretReloadSignal:
+		if reload.Signal != "" {
+			if _, err := signal.ParseSignal(reload.Signal); err != nil {
+				invalid.add("spec.secretReload.signal", "%v", err)
+			}
+		}
+	default:
+		invalid.add("spec.secretReload.strategy", "%q must be %v, %v, %v or %v", reload.Strategy, SecretReloadRecreate, SecretReloadSignal, SecretReloadFiles, SecretReloadNone)
+		return
+	}
+
+	switch strings.ToLower(reload.Strategy) {
+	case SecretReloadFiles, SecretReloadSignal:
+		if hasEnvSecrets(req) {
+			invalid.add("spec.secretReload.strategy", "secrets in the environment are only reloaded by %v, deliver them as files", SecretReloadRecreate)
+		}
+	}
+}
+
+func validateContainer(req DeploymentRequest, invalid *ValidationError) {
+	container := req.Container
+
+	if container.Name == "" {
+		invalid.add("container.name", "is required")
+	} else if !containerNamePattern.MatchString(container.Name) {
+		invalid.add("container.name", "%q must match %v", container.Name, containerNamePattern)
+	}
+
+	if container.Image == "" {
+		invalid.add("container.image", "is required")
+	} else if _, err := reference.ParseNormalizedNamed(container.Image); err != nil {
+		invalid.add("container.image", "%q is not a valid image reference: %v", container.Image, err)
+	}
+
+	for i, host := range container.ExtraHosts {
+		name, ip, ok := strings.Cut(host, ":")
+		if !ok || name == "" || net.ParseIP(ip) == nil {
+			invalid.add(fmt.Sprintf("container.extraHosts[%d]", i), "%q must be hostname:IP", host)
+		}
+	}
+
+	for i, server := range container.DNS {
+		if net.ParseIP(server) == nil {
+			invalid.add(fmt.Sprintf("container.dns[%d]", i), "%q is not an IP address", server)
+		}
+	}
+}
+
+func validatePorts(req DeploymentRequest, invalid *ValidationError) {
+	container := req.Container
+
+	if container.Binding != "" && net.ParseIP(container.Binding) == nil {
+		invalid.add("container.binding", "%q is not an IP address", container.Binding)
+	}
+	if container.ContainerPort == "" && container.HostPort != "" {
+		invalid.add("container.hostPort", "requires containerPort")
+	}
+	if container.ContainerPort != "" {
+		validatePort("container", Port{ContainerPort: container.ContainerPort, HostPort: container.HostPort}, req, invalid)
+	}
+
+	for i, port := range container.Ports {
+		field := fmt.Sprintf("container.ports[%d]", i)
+		validatePort(field, port, req, invalid)
+
+		for j, hostIP := range port.HostIPs {
+			if net.ParseIP(hostIP) == nil {
+				invalid.add(fmt.Sprintf("%v.hostIPs[%d]", field, j), "%q is not an IP address", hostIP)
+			}
+		}
+	}
+}
+
+func validatePort(field string, port Port, req DeploymentRequest, invalid *ValidationError) {
+	switch strings.ToLower(port.Protocol) {
+	case "", "tcp", "udp", "sctp":
+	default:
+		invalid.add(field+".protocol", "%q must be tcp, udp or sctp", port.Protocol)
+	}
+
+	start, end, err := nat.ParsePortRange(port.ContainerPort)
+	if err != nil || start == 0 {
+		invalid.add(field+".containerPort", "%q is not a port or range between 1 and 65535", port.ContainerPort)
+		return
+	}
+
+	if port.HostPort == "" {
+		return
+	}
+
+	hostStart, _, err := nat.ParsePortRange(port.HostPort)
+	if err != nil || hostStart == 0 {
+		invalid.add(field+".hostPort", "%q is not a port or range between 1 and 65535", port.HostPort)
+		return
+	}
+
+	if _, _, err := replicaHostPortStart(port, end-start+1, 0, replicaCount(req)); err != nil {
+		invalid.add(field+".hostPort", "%v", err)
+	}
+}
+
+// validateEnv checks the environment variable names and resolves every secret
+func (docker *dockerCmd) validateEnv(req DeploymentRequest, invalid *ValidationError) {
+	names := map[string]string{}
+	files := map[string]string{}
+
+	for i, envVar := range req.Container.EnvVars {
+		field := fmt.Sprintf("container.envVars[%d].name", i)
+		switch {
+		case envVar.Name == "":
+			invalid.add(field, "is required")
+		case strings.Contains(envVar.Name, "="):
+			invalid.add(field, "%q must not contain =", envVar.Name)
+		case names[envVar.Name] != "":
+			invalid.add(field, "%v is already set by %v", envVar.Name, names[envVar.Name])
+		default:
+			names[envVar.Name] = field
+		}
+	}
+
+	for i, secret := range req.Container.Secrets {
+		field := fmt.Sprintf("container.secrets[%d]", i)
+		if secret.SecretPath == "" {
+			invalid.add(field+".secretPath", "is required")
+		}
+		if secret.SecretKey == "" {
+			invalid.add(field+".secretKey", "is required")
+			continue
+		}
+
+		switch strings.ToLower(secret.Delivery) {
+		case "", SecretDeliveryEnv:
+			if secret.Path != "" || secret.Mode != "" || secret.Owner != "" {
+				invalid.add(field, "path, mode and owner require delivery %v", SecretDeliveryFile)
+			}
+
+			nameField := field + ".secretKey"
+			if secret.Name != "" {
+				nameField = field + ".name"
+			}
+			name := secretEnvName(secret)
+
+			switch {
+			case strings.Contains(name, "="):
+				invalid.add(nameField, "%q must not contain =", name)
+			case names[name] != "":
+				invalid.add(nameField, "%v is already set by %v", name, names[name])
+			default:
+				names[name] = nameField
+			}
+		case SecretDeliveryFile:
+			if docker.fileSecretsErr != nil {
+				invalid.add(field+".delivery", "%v", docker.fileSecretsErr)
+			}
+			validateSecretFile(field, secret, files, invalid)
+		default:
+			invalid.add(field+".delivery", "%q must be %v or %v", secret.Delivery, SecretDeliveryEnv, SecretDeliveryFile)
+		}
+
+		if _, err := docker.resolveSecret(secret); err != nil {
+			invalid.add(field, "secret %v cannot be resolved: %v", secretName(secret), err)
+		}
+	}
+}
+
+// validateSecretFile checks the path, mode and owner of a secret delivered as a file
+func validateSecretFile(field string, secret Secret, files map[string]string, invalid *ValidationError) {
+	filePath := secretFilePath(secret)
+	pathField := field + ".path"
+	switch {
+	case secret.Path != "":
+	case secret.Name != "":
+		pathField = field + ".name"
+	default:
+		pathField = field + ".secretKey"
+	}
+
+	relative := cmp.Or(secret.Path, secretEnvName(secret))
+
+	switch {
+	case path.IsAbs(relative):
+		invalid.add(pathField, "%q must be relative to %v", relative, secretsDir)
+	case slices.Contains(strings.Split(relative, "/"), ".."):
+		invalid.add(pathField, "%q must not contain ..", relative)
+	case strings.HasSuffix(relative, "/") || filePath == secretsDir:
+		invalid.add(pathField, "%q is not a file path", relative)
+	case files[filePath] != "":
+		invalid.add(pathField, "%v is already written by %v", filePath, files[filePath])
+	default:
+		files[filePath] = pathField
+	}
+
+	if _, err := secretFileMode(secret); err != nil {
+		invalid.add(field+".mode", "%v", err)
+	}
+	if _, _, err := secretFileOwner(secret); err != nil {
+		invalid.add(field+".owner", "%v", err)
+	}
+}
+
+func validateProbe(field string, probe *Probe, invalid *ValidationError) {
+	if probe == nil {
+		return
+	}
+
+	actions := 0
+	if probe.HTTPGet != nil {
+		actions++
+		if probe.HTTPGet.Port < 1 || probe.HTTPGet.Port > 65535 {
+			invalid.add(field+".httpGet.port", "%v is not between 1 and 65535", probe.HTTPGet.Port)
+		}
+		switch strings.ToLower(probe.HTTPGet.Scheme) {
+		case "", "http", "https":
+		default:
+			invalid.add(field+".httpGet.scheme", "%q must be http or https", probe.HTTPGet.Scheme)
+		}
+	}
+	if probe.TCPSocket != nil {
+		actions++
+		if probe.TCPSocket.Port < 1 || probe.TCPSocket.Port > 65535 {
+			invalid.add(field+".tcpSocket.port", "%v is not between 1 and 65535", probe.TCPSocket.Port)
+		}
+	}
+	if probe.Exec != nil {
+		actions++
+		if len(probe.Exec.Command) == 0 {
+			invalid.add(field+".exec.command", "is required")
+		}
+	}
+	if actions != 1 {
+		invalid.add(field, "must have exactly one of httpGet, tcpSocket or exec")
+	}
+
+	if probe.InitialDelaySeconds < 0 || probe.PeriodSeconds < 0 || probe.TimeoutSeconds < 0 || probe.FailureThreshold < 0 {
+		invalid.add(field, "durations and thresholds must not be negative")
+	}
+}
+
+func (docker *dockerCmd) validateVolumes(req DeploymentRequest, invalid *ValidationError) {
+	targets := map[string]bool{}
+
+	// The directories of the secret files are mounted as well
+	for _, dir := range secretFileDirs(req) {
+		targets[dir] = true
+	}
+
+	for i, vol := range req.Container.Volumes {
+		field := fmt.Sprintf("container.volumes[%d]", i)
+
+		if !path.IsAbs(vol.Target) {
+			invalid.add(field+".target", "%q is not an absolute path", vol.Target)
+		} else if targets[path.Clean(vol.Target)] {
+			invalid.add(field+".target", "%v is already mounted", vol.Target)
+		}
+		targets[path.Clean(vol.Target)] = true
+
+		single := req
+		single.Container.Volumes = []Volume{vol}
+		if vol.Target != "" {
+			if _, err := replicaMounts(single); err != nil {
+				invalid.add(field, "%v", err)
+			} else if volumeType(vol) == mount.TypeBind {
+				if err := docker.bindSourceAllowed(vol.Source); err != nil {
+					invalid.add(field+".source", "%v", err)
+				}
+			} else if err := docker.volumeDriverAllowed(vol); err != nil {
+				driverField := field + ".driverOpts.device"
+				if !isLocalDriver(vol.Driver) {
+					driverField = field + ".driver"
+				}
+				invalid.add(driverField, "%v", err)
+			}
+		}
+	}
+}
+
+func validateNetworks(req DeploymentRequest, invalid *ValidationError) {
+	if _, err := replicaNetworks(req, defaultNetwork); err != nil {
+		invalid.add("container.networks", "%v", err)
+	}
+
+	for i, net := range req.Container.Networks {
+		field := fmt.Sprintf("container.networks[%d]", i)
+		if net.IPv4Address != "" && !isIPv4(net.IPv4Address) {
+			invalid.add(field+".ipv4Address", "%q is not an IPv4 address", net.IPv4Address)
+		}
+		if net.IPv6Address != "" && !isIPv6(net.IPv6Address) {
+			invalid.add(field+".ipv6Address", "%q is not an IPv6 address", net.IPv6Address)
+		}
+	}
+}
+
+func isIPv4(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.To4() != nil
+}
+
+func isIPv6(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.To4() == nil
+}
@@ -0,0 +1,209 @@
+package deployment
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestValidateFields(t *testing.T) {
+	docker := &dockerCmd{
+		secrets: func(secretPath string, secretKey string) (string, error) {
+			if secretPath == "/Missing" {
+				return "", errors.New("not found")
+			}
+			return "value", nil
+		},
+	}
+
+	tests := []struct {
+		name    string
+		request string
+		want    []string
+	}{
+		{
+			name:    "valid",
+			request: `{"container": {"name": "api", "image": "nginx"}}`,
+		},
+		{
+			name:    "missing name and image",
+			request: `{"container": {}}`,
+			want:    []string{"container.name", "container.image"},
+		},
+		{
+			name:    "host port out of range",
+			request: `{"container": {"name": "api", "image": "nginx", "ports": [{"containerPort": "80"}, {"containerPort": "443", "hostPort": "70000"}]}}`,
+			want:    []string{"container.ports[1].hostPort"},
+		},
+		{
+			name:    "duplicate environment variable",
+			request: `{"container": {"name": "api", "image": "nginx", "envVars": [{"name": "A"}, {"name": "A"}]}}`,
+			want:    []string{"container.envVars[1].name"},
+		},
+		{
+			name:    "secret without path nor key",
+			request: `{"container": {"name": "api", "image": "nginx", "secrets": [{}]}}`,
+			want:    []string{"container.secrets[0].secretPath", "container.secrets[0].secretKey"},
+		},
+		{
+			name:    "secret named as an environment variable",
+			request: `{"container": {"name": "api", "image": "nginx", "envVars": [{"name": "URL"}], "secrets": [{"secretPath": "/Nats", "secretKey": "KEY", "name": "URL"}]}}`,
+			want:    []string{"container.secrets[0].name"},
+		},
+		{
+			name:    "secret key set as an environment variable",
+			request: `{"container": {"name": "api", "image": "nginx", "envVars": [{"name": "URL"}], "secrets": [{"secretPath": "/Nats", "secretKey": "URL"}]}}`,
+			want:    []string{"container.secrets[0].secretKey"},
+		},
+		{
+			name:    "unresolved secret",
+			request: `{"container": {"name": "api", "image": "nginx", "secrets": [{"secretPath": "/Missing", "secretKey": "URL"}]}}`,
+			want:    []string{"container.secrets[0]"},
+		},
+		{
+			name:    "file secret with an absolute path",
+			request: `{"container": {"name": "api", "image": "nginx", "secrets": [{"secretPath": "/Nats", "secretKey": "URL", "delivery": "file", "path": "/etc/passwd"}]}}`,
+			want:    []string{"container.secrets[0].path"},
+		},
+		{
+			name:    "file secret outside /run/secrets",
+			request: `{"container": {"name": "api", "image": "nginx", "secrets": [{"secretPath": "/Nats", "secretKey": "URL", "delivery": "file", "path": "../../etc/passwd"}]}}`,
+			want:    []string{"container.secrets[0].path"},
+		},
+		{
+			name:    "file secret named outside /run/secrets",
+			request: `{"container": {"name": "api", "image": "nginx", "secrets": [{"secretPath": "/Nats", "secretKey": "URL", "delivery": "file", "name": "../passwd"}]}}`,
+			want:    []string{"container.secrets[0].name"},
+		},
+		{
+			name:    "file secrets written to the same file",
+			request: `{"container": {"name": "api", "image": "nginx", "secrets": [{"secretPath": "/Nats", "secretKey": "URL", "delivery": "file"}, {"secretPath": "/Db", "secretKey": "URL", "delivery": "file"}]}}`,
+			want:    []string{"container.secrets[1].secretKey"},
+		},
+		{
+			name:    "file secret mode and owner",
+			request: `{"container": {"name": "api", "image": "nginx", "secrets": [{"secretPath": "/Nats", "secretKey": "URL", "delivery": "file", "mode": "999", "owner": "app"}]}}`,
+			want:    []string{"container.secrets[0].mode", "container.secrets[0].owner"},
+		},
+		{
+			name:    "file options of an environment secret",
+			request: `{"container": {"name": "api", "image": "nginx", "secrets": [{"secretPath": "/Nats", "secretKey": "URL", "mode": "0400"}]}}`,
+			want:    []string{"container.secrets[0]"},
+		},
+		{
+			name:    "unknown delivery",
+			request: `{"container": {"name": "api", "image": "nginx", "secrets": [{"secretPath": "/Nats", "secretKey": "URL", "delivery": "volume"}]}}`,
+			want:    []string{"container.secrets[0].delivery"},
+		},
+		{
+			name:    "volume mounted over the secret files",
+			request: `{"container": {"name": "api", "image": "nginx", "secrets": [{"secretPath": "/Nats", "secretKey": "URL", "delivery": "file"}], "volumes": [{"source": "data", "target": "/run/secrets"}]}}`,
+			want:    []string{"container.volumes[0].target"},
+		},
+		{
+			name:    "signal reload of environment secrets",
+			request: `{"spec": {"secretReload": {"strategy": "signal"}}, "container": {"name": "api", "image": "nginx", "secrets": [{"secretPath": "/Nats", "secretKey": "URL"}]}}`,
+			want:    []string{"spec.secretReload.strategy"},
+		},
+		{
+			name:    "signal of another reload strategy",
+			request: `{"spec": {"secretReload": {"strategy": "files", "signal": "SIGHUP"}}, "container": {"name": "api", "image": "nginx"}}`,
+			want:    []string{"spec.secretReload.signal"},
+		},
+		{
+			name:    "unknown reload signal",
+			request: `{"spec": {"secretReload": {"strategy": "signal", "signal": "SIGNOPE"}}, "container": {"name": "api", "image": "nginx"}}`,
+			want:    []string{"spec.secretReload.signal"},
+		},
+		{
+			name:    "unknown reload strategy",
+			request: `{"spec": {"secretReload": {"strategy": "restart"}}, "container": {"name": "api", "image": "nginx"}}`,
+			want:    []string{"spec.secretReload.strategy"},
+		},
+		{
+			name:    "bind mount outside the allowed roots",
+			request: `{"container": {"name": "api", "image": "nginx", "volumes": [{"type": "bind", "source": "/etc", "target": "/data"}]}}`,
+			want:    []string{"container.volumes[0].source"},
+		},
+		{
+			name:    "local volume binding a host path",
+			request: `{"container": {"name": "api", "image": "nginx", "volumes": [{"source": "root", "target": "/host", "driver": "local", "driverOpts": {"type": "none", "o": "bind", "device": "/"}}]}}`,
+			want:    []string{"container.volumes[0].driverOpts.device"},
+		},
+		{
+			name:    "local volume mounting a host device",
+			request: `{"container": {"name": "api", "image": "nginx", "volumes": [{"source": "disk", "target": "/disk", "driverOpts": {"type": "ext4", "device": "/dev/sda1"}}]}}`,
+			want:    []string{"container.volumes[0].driverOpts.device"},
+		},
+		{
+			name:    "local tmpfs volume",
+			request: `{"container": {"name": "api", "image": "nginx", "volumes": [{"source": "cache", "target": "/cache", "driverOpts": {"type": "tmpfs", "device": "tmpfs"}}]}}`,
+		},
+		{
+			name:    "volume driver plugin",
+			request: `{"container": {"name": "api", "image": "nginx", "volumes": [{"source": "data", "target": "/data", "driver": "local-persist"}]}}`,
+			want:    []string{"container.volumes[0].driver"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req DeploymentRequest
+			if err := json.Unmarshal([]byte(test.request), &req); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			var invalid *ValidationError
+			if err := docker.Validate(req); errors.As(err, &invalid) {
+				for _, fieldError := range invalid.Errors {
+					got = append(got, fieldError.Field)
+				}
+			} else if err != nil {
+				t.Fatalf("Validate returned %v, want a *ValidationError", err)
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("invalid fields = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateCronJobRun(t *testing.T) {
+	docker := &dockerCmd{}
+
+	historyLimit := 5
+	var req DeploymentRequest
+	req.Kind = KindCronJob
+	req.Spec.Schedule = "*/5 * * * *"
+	req.Spec.ConcurrencyPolicy = ConcurrencyForbid
+	req.Spec.HistoryLimit = &historyLimit
+	req.Container.Name = "cleanup"
+	req.Container.Image = "alpine"
+
+	if err := docker.Validate(req); err != nil {
+		t.Fatalf("Validate(cron job) = %v", err)
+	}
+	if err := docker.Validate(cronJobRun(req)); err != nil {
+		t.Errorf("Validate(run of the cron job) = %v", err)
+	}
+}
+
+func TestValidateFileSecretsWithoutTmpfs(t *testing.T) {
+	docker := &dockerCmd{
+		secrets:        func(string, string) (string, error) { return "value", nil },
+		fileSecretsErr: errors.New("not a tmpfs"),
+	}
+
+	var req DeploymentRequest
+	req.Container.Name = "api"
+	req.Container.Image = "nginx"
+	req.Container.Secrets = []Secret{{SecretPath: "/Nats", SecretKey: "URL", Delivery: SecretDeliveryFile}}
+
+	var invalid *ValidationError
+	if err := docker.Validate(req); !errors.As(err, &invalid) || len(invalid.Errors) != 1 || invalid.Errors[0].Field != "container.secrets[0].delivery" {
+		t.Errorf("Validate = %v, want an error on container.secrets[0].delivery", err)
+	}
+}
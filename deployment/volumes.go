@@ -0,0 +1,182 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-units"
+	"io/fs"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// replicaMounts returns the mounts of the containers of a request
+func replicaMounts(req DeploymentRequest) ([]mount.Mount, error) {
+	var mounts []mount.Mount
+
+	for _, vol := range req.Container.Volumes {
+		if vol.Target == "" {
+			return nil, fmt.Errorf("volume %q has no target", vol.Source)
+		}
+
+		m := mount.Mount{
+			Source:   vol.Source,
+			Target:   vol.Target,
+			ReadOnly: vol.ReadOnly,
+		}
+
+		switch volumeType(vol) {
+		case mount.TypeVolume:
+			if vol.Source == "" {
+				return nil, fmt.Errorf("volume mounted at %v has no source", vol.Target)
+			}
+			m.Type = mount.TypeVolume
+		case mount.TypeBind:
+			if !strings.HasPrefix(vol.Source, "/") {
+				return nil, fmt.Errorf("bind mount source %q is not an absolute path", vol.Source)
+			}
+			m.Type = mount.TypeBind
+		case mount.TypeTmpfs:
+			options, err := tmpfsOptions(vol)
+			if err != nil {
+				return nil, err
+			}
+			m.Type = mount.TypeTmpfs
+			m.Source = ""
+			m.TmpfsOptions = options
+		default:
+			return nil, fmt.Errorf("unknown type %q for volume mounted at %v", vol.Type, vol.Target)
+		}
+
+		mounts = append(mounts, m)
+	}
+
+	return mounts, nil
+}
+
+// checkBindMounts refuses the bind mounts of host paths outside the allowed roots, including
+// the named volumes mounting a host path, unless privileged containers are allowed
+func (docker *dockerCmd) checkBindMounts(req DeploymentRequest) error {
+	for _, vol := range req.Container.Volumes {
+		var err error
+		switch volumeType(vol) {
+		case mount.TypeBind:
+			err = docker.bindSourceAllowed(vol.Source)
+		case mount.TypeVolume:
+			err = docker.volumeDriverAllowed(vol)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// volumeDriverAllowed returns an error when a named volume is created by another driver
+// than local, or mounts a host path or device outside the allowed roots
+func (docker *dockerCmd) volumeDriverAllowed(vol Volume) error {
+	if docker.security.AllowPrivileged {
+		return nil
+	}
+
+	if !isLocalDriver(vol.Driver) {
+		return fmt.Errorf("volume driver %v is not allowed, only local volumes are allowed", vol.Driver)
+	}
+
+	// The local driver mounts its device option, a host path when it starts with /
+	if device := vol.DriverOpts["device"]; strings.HasPrefix(device, "/") {
+		return docker.bindSourceAllowed(device)
+	}
+	return nil
+}
+
+func isLocalDriver(driver string) bool {
+	return driver == "" || driver == "local"
+}
+
+// bindSourceAllowed returns an error when a host path may not be bind mounted
+func (docker *dockerCmd) bindSourceAllowed(source string) error {
+	if docker.security.AllowPrivileged {
+		return nil
+	}
+
+	source = path.Clean(source)
+	for _, root := range docker.bindMountRoots {
+		root = path.Clean(root)
+		if root != "/" && (source == root || strings.HasPrefix(source, root+"/")) {
+			return nil
+		}
+	}
+
+	if len(docker.bindMountRoots) == 0 {
+		return fmt.Errorf("bind mount of %v is not allowed, no host path is allowed", source)
+	}
+	return fmt.Errorf("bind mount of %v is not allowed, host paths must be under %v", source, strings.Join(docker.bindMountRoots, ", "))
+}
+
+func volumeType(vol Volume) mount.Type {
+	if vol.Type == "" {
+		return mount.TypeVolume
+	}
+	return mount.Type(strings.ToLower(vol.Type))
+}
+
+func tmpfsOptions(vol Volume) (*mount.TmpfsOptions, error) {
+	options := &mount.TmpfsOptions{}
+
+	if vol.Size != "" {
+		size, err := units.RAMInBytes(vol.Size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tmpfs size %q: %w", vol.Size, err)
+		}
+		options.SizeBytes = size
+	}
+
+	if vol.Mode != "" {
+		mode, err := strconv.ParseUint(vol.Mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tmpfs mode %q: %w", vol.Mode, err)
+		}
+		options.Mode = fs.FileMode(mode)
+	}
+
+	return options, nil
+}
+
+// ensureVolumes creates the named volumes of a request that do not exist yet. Existing
+// volumes are left untouched so their data survives redeploys.
+func (docker *dockerCmd) ensureVolumes(ctx context.Context, req DeploymentRequest) error {
+	for _, vol := range req.Container.Volumes {
+		if volumeType(vol) != mount.TypeVolume {
+			continue
+		}
+
+		_, err := docker.cli.VolumeInspect(ctx, vol.Source)
+		if err == nil {
+			continue
+		}
+		if !errdefs.IsNotFound(err) {
+			return err
+		}
+
+		log.Printf("Creating volume: %v\n", vol.Source)
+		_, err = docker.cli.VolumeCreate(ctx, volume.CreateOptions{
+			Name:       vol.Source,
+			Driver:     vol.Driver,
+			DriverOpts: vol.DriverOpts,
+			Labels: map[string]string{
+				LabelManagedBy:  managedBy,
+				LabelDeployment: deploymentName(req),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("error creating volume %v: %w", vol.Source, err)
+		}
+	}
+
+	return nil
+}
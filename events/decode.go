@@ -0,0 +1,92 @@
+package events
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"gopkg.in/yaml.v3"
+	"io"
+	"mime"
+	"strings"
+)
+
+// Content types of the event data. Base64 YAML is what the GitHub action sends.
+const (
+	ContentTypeJSON       = "application/json"
+	ContentTypeYAML       = "application/yaml"
+	ContentTypeYAMLBase64 = "application/yaml; encoding=base64"
+)
+
+// DecodeData decodes the data of an event according to its datacontenttype, JSON when unset
+func DecodeData(event cloudevents.Event, v interface{}) error {
+	return Decode(event.DataContentType(), event.Data(), v)
+}
+
+// Decode decodes JSON, YAML or base64 YAML data into v, rejecting unknown fields
+func Decode(contentType string, data []byte, v interface{}) error {
+	if contentType == "" {
+		contentType = ContentTypeJSON
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid content type %q: %w", contentType, err)
+	}
+
+	switch {
+	case mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json"):
+		return decodeJSON(data, v)
+
+	case mediaType == "application/yaml" || mediaType == "application/x-yaml" || mediaType == "text/yaml" || strings.HasSuffix(mediaType, "+yaml"):
+		switch params["encoding"] {
+		case "":
+		case "base64":
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+			if err != nil {
+				return fmt.Errorf("data is not valid base64: %w", err)
+			}
+			data = decoded
+		default:
+			return fmt.Errorf("unsupported encoding %q", params["encoding"])
+		}
+		return decodeYAML(data, v)
+	}
+
+	return fmt.Errorf("unsupported content type %q", contentType)
+}
+
+func decodeJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("data is not valid JSON: %w", err)
+	}
+	if decoder.More() {
+		return errors.New("data has content after the JSON value")
+	}
+
+	return nil
+}
+
+func decodeYAML(data []byte, v interface{}) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("data is empty")
+		}
+		return fmt.Errorf("data is not valid YAML: %w", err)
+	}
+
+	var extra interface{}
+	if err := decoder.Decode(&extra); !errors.Is(err, io.EOF) {
+		return errors.New("data has more than one YAML document")
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package events
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+type manifest struct {
+	Name     string `json:"name" yaml:"name"`
+	Replicas int    `json:"replicas" yaml:"replicas"`
+}
+
+func TestDecode(t *testing.T) {
+	yamlManifest := "name: api\nreplicas: 2\n"
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        string
+		want        manifest
+		wantErr     bool
+	}{
+		{name: "JSON by default", data: `{"name": "api", "replicas": 2}`, want: manifest{"api", 2}},
+		{name: "JSON", contentType: ContentTypeJSON, data: `{"name": "api", "replicas": 2}`, want: manifest{"api", 2}},
+		{name: "JSON with a charset", contentType: "application/json; charset=utf-8", data: `{"name": "api"}`, want: manifest{Name: "api"}},
+		{name: "JSON suffix", contentType: "application/cloudevents+json", data: `{"name": "api"}`, want: manifest{Name: "api"}},
+		{name: "unknown JSON field", contentType: ContentTypeJSON, data: `{"name": "api", "replica": 2}`, wantErr: true},
+		{name: "content after the JSON value", contentType: ContentTypeJSON, data: `{"name": "api"} {"name": "web"}`, wantErr: true},
+		{name: "YAML", contentType: ContentTypeYAML, data: yamlManifest, want: manifest{"api", 2}},
+		{name: "YAML alias", contentType: "text/yaml", data: yamlManifest, want: manifest{"api", 2}},
+		{name: "base64 YAML", contentType: ContentTypeYAMLBase64, data: base64.StdEncoding.EncodeToString([]byte(yamlManifest)) + "\n", want: manifest{"api", 2}},
+		{name: "invalid base64", contentType: ContentTypeYAMLBase64, data: "not base64!", wantErr: true},
+		{name: "unsupported encoding", contentType: "application/yaml; encoding=gzip", data: yamlManifest, wantErr: true},
+		{name: "unknown YAML field", contentType: ContentTypeYAML, data: "name: api\nreplica: 2\n", wantErr: true},
+		{name: "several YAML documents", contentType: ContentTypeYAML, data: yamlManifest + "---\nname: web\n", wantErr: true},
+		{name: "empty YAML", contentType: ContentTypeYAML, data: "", wantErr: true},
+		{name: "unsupported content type", contentType: "text/plain", data: "api", wantErr: true},
+		{name: "invalid content type", contentType: "application/", data: "{}", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got manifest
+			err := Decode(test.contentType, []byte(test.data), &got)
+
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("Decode(%q) = %+v, want an error", test.contentType, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode(%q) = %v", test.contentType, err)
+			}
+			if got != test.want {
+				t.Errorf("Decode(%q) = %+v, want %+v", test.contentType, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"DeploymentManager/redact"
+	"encoding/json"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Subjects of the events consumed and published by the manager. The subject of a
+// JetStream message is also the type of the CloudEvent it carries.
+const (
+	ImageCreated   = "Stack.Containers.ImageCreated"
+	BuildRequested = "Stack.Containers.BuildRequested"
+	NewSecret      = "Stack.Secrets.NewSecret2"
+
+	BuildFailed = "Stack.Containers.BuildFailed"
+
+	DeploymentStarted    = "Stack.Deployments.Started"
+	DeploymentSucceeded  = "Stack.Deployments.Succeeded"
+	DeploymentFailed     = "Stack.Deployments.Failed"
+	DeploymentRolledBack = "Stack.Deployments.RolledBack"
+	DeploymentDrifted    = "Stack.Deployments.Drifted"
+
+	JobCompleted = "Stack.Jobs.Completed"
+	JobFailed    = "Stack.Jobs.Failed"
+)
+
+const (
+	// Source of the events published by the manager
+	Source = "DeploymentManager"
+
+	// CorrelationExtension holds the ID of the event that triggered a deployment
+	CorrelationExtension = "correlationid"
+)
+
+// DeploymentOutcome is the data of the Stack.Deployments.* events
+type DeploymentOutcome struct {
+	Deployment   string   `json:"deployment"`
+	Revision     int      `json:"revision,omitempty"`
+	Image        string   `json:"image"`
+	ImageDigest  string   `json:"imageDigest,omitempty"`
+	ContainerIDs []string `json:"containerIds,omitempty"`
+	DurationMs   int64    `json:"durationMs"`
+	RolledBack   bool     `json:"rolledBack"`
+	Error        string   `json:"error,omitempty"`
+	// ValidationErrors lists the invalid fields of a rejected request as "field: message"
+	ValidationErrors []string `json:"validationErrors,omitempty"`
+}
+
+// JobOutcome is the data of the Stack.Jobs.* events
+type JobOutcome struct {
+	Job         string `json:"job"`
+	Revision    int    `json:"revision,omitempty"`
+	Image       string `json:"image"`
+	ImageDigest string `json:"imageDigest,omitempty"`
+	ContainerID string `json:"containerId,omitempty"`
+	ExitCode    int    `json:"exitCode"`
+	Attempts    int    `json:"attempts"`
+	DurationMs  int64  `json:"durationMs"`
+	Logs        string `json:"logs,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// ValidationErrors lists the invalid fields of a rejected request as "field: message"
+	ValidationErrors []string `json:"validationErrors,omitempty"`
+}
+
+// BuildOutcome is the data of the Stack.Containers.BuildFailed events
+type BuildOutcome struct {
+	Image      string `json:"image"`
+	Deployment string `json:"deployment,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// SecretChange is the data of the Stack.Secrets.NewSecret2 events. Without secretKey every
+// secret of the folder secretPath changed, and without data every secret changed.
+type SecretChange struct {
+	SecretPath string `json:"secretPath" yaml:"secretPath"`
+	SecretKey  string `json:"secretKey,omitempty" yaml:"secretKey"`
+}
+
+// NewEvent returns an event of the given type published by the manager, the secret values
+// found in its data are masked
+func NewEvent(eventType string, data interface{}) (cloudevents.Event, error) {
+	event := cloudevents.NewEvent()
+	event.SetID(uuid.NewString())
+	event.SetType(eventType)
+	event.SetSource(Source)
+	event.SetTime(time.Now())
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return event, err
+	}
+
+	if err := event.SetData(cloudevents.ApplicationJSON, redact.Bytes(encoded)); err != nil {
+		return event, err
+	}
+
+	return event, event.Validate()
+}
+
+// NewCorrelatedEvent returns an event of the given type correlated with the event that caused it
+func NewCorrelatedEvent(eventType string, cause cloudevents.Event, data interface{}) (cloudevents.Event, error) {
+	event, err := NewEvent(eventType, data)
+	if err != nil {
+		return event, err
+	}
+
+	event.SetExtension(CorrelationExtension, cause.ID())
+
+	return event, event.Validate()
+}
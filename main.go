@@ -1,17 +1,21 @@
 package main
 
 import (
+	"DeploymentManager/api"
 	"DeploymentManager/deployment"
+	"DeploymentManager/events"
 	"DeploymentManager/nats"
+	"DeploymentManager/redact"
 	"DeploymentManager/secrets"
-	"DeploymentManager/utils"
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/nats-io/nats.go/jetstream"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -41,16 +45,33 @@ func initSecretManager() secrets.SecretManager {
 	return clientSecret
 }
 
-func initDockerClient(ctx context.Context) deployment.Docker {
+func initDockerClient(ctx context.Context, clientSecret secrets.SecretManager) deployment.Docker {
 	log.Println("Creating docker client")
 	start := time.Now()
 
+	statePath := os.Getenv("STATE_PATH")
+	if statePath == "" {
+		statePath = "/data/deployments.db"
+	}
+
 	dockerClient, err := deployment.NewClient(
 		deployment.Configs{
-			Host:     "unix:///var/run/deployment.sock",
-			Registry: os.Getenv("DOCKER_PRIVATE_REGISTRY"),
-			Username: os.Getenv("DOCKER_USERNAME"),
-			Password: os.Getenv("DOCKER_PASSWORD"),
+			Host:      "unix:///var/run/deployment.sock",
+			Registry:  os.Getenv("DOCKER_PRIVATE_REGISTRY"),
+			Username:  os.Getenv("DOCKER_USERNAME"),
+			Password:  os.Getenv("DOCKER_PASSWORD"),
+			StatePath: statePath,
+			Network:   os.Getenv("DOCKER_NETWORK"),
+			Security:  securityDefaults(),
+			// Must be on a tmpfs of the host, such as /run
+			SecretFilesDir:      os.Getenv("SECRET_FILES_DIR"),
+			RotationConcurrency: rotationConcurrency(),
+			BindMountRoots:      envList("BIND_MOUNT_ROOTS"),
+			BuildsRoot:          os.Getenv("BUILDS_ROOT"),
+			Secrets: func(secretPath string, secretKey string) (string, error) {
+				secret, err := clientSecret.Get(secretPath, secretKey)
+				return secret.SecretValue, err
+			},
 		})
 
 	if err != nil {
@@ -60,11 +81,58 @@ func initDockerClient(ctx context.Context) deployment.Docker {
 	// Check login to private registry successful
 	dockerClient.RegistryLogin(ctx)
 
+	// Recover the deployments recorded on managed containers
+	if err := dockerClient.RebuildState(ctx); err != nil {
+		log.Printf("Error rebuilding state from containers: %v\n", err)
+	}
+
 	log.Printf("Docker client created in %s", time.Since(start))
 
 	return dockerClient
 }
 
+// rotationConcurrency reads the number of deployments rolled at once on secret rotation
+func rotationConcurrency() int {
+	value := os.Getenv("SECRET_ROTATION_CONCURRENCY")
+	if value == "" {
+		return 0
+	}
+
+	concurrency, err := strconv.Atoi(value)
+	if err != nil || concurrency < 1 {
+		log.Fatalf("Invalid SECRET_ROTATION_CONCURRENCY: %q\n", value)
+	}
+	return concurrency
+}
+
+// envList reads a comma separated environment variable, ignoring spaces and empty entries
+func envList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// securityDefaults reads the security options applied to every container, privileged
+// containers are refused unless SECURITY_ALLOW_PRIVILEGED is true
+func securityDefaults() deployment.SecurityDefaults {
+	defaults := deployment.SecurityDefaults{
+		ReadOnlyRootFilesystem: os.Getenv("SECURITY_READ_ONLY_ROOTFS") == "true",
+		NoNewPrivileges:        os.Getenv("SECURITY_NO_NEW_PRIVILEGES") == "true",
+		SeccompProfile:         os.Getenv("SECURITY_SECCOMP_PROFILE"),
+		SeccompProfileDir:      os.Getenv("SECURITY_SECCOMP_PROFILE_DIR"),
+		AppArmorProfile:        os.Getenv("SECURITY_APPARMOR_PROFILE"),
+		UsernsMode:             os.Getenv("SECURITY_USERNS_MODE"),
+		AllowPrivileged:        os.Getenv("SECURITY_ALLOW_PRIVILEGED") == "true",
+		CapDrop:                envList("SECURITY_CAP_DROP"),
+	}
+
+	return defaults
+}
+
 func initNats(ctx context.Context) jetstream.Consumer {
 	log.Println("Creating NATS JetStream consumer")
 	start := time.Now()
@@ -90,25 +158,113 @@ func initNats(ctx context.Context) jetstream.Consumer {
 
 }
 
+func initApi(ctx context.Context, dockerClient deployment.Docker) {
+	if os.Getenv("API_TLS_CERT") == "" {
+		log.Println("API_TLS_CERT not set, deployment API disabled")
+		return
+	}
+
+	addr := os.Getenv("API_LISTEN_ADDR")
+	if addr == "" {
+		addr = ":8443"
+	}
+
+	// Either publish the request on JetStream or deploy it from this process
+	dispatch := func(reqCtx context.Context, event cloudevents.Event) error {
+		return nats.Publish(reqCtx, event.Type(), event)
+	}
+	if os.Getenv("API_DISPATCH") == "direct" {
+		dispatch = func(_ context.Context, event cloudevents.Event) error {
+			go processNewImageCreated(ctx, dockerClient, event)
+			return nil
+		}
+	}
+
+	server, err := api.NewServer(api.Config{
+		Addr:         addr,
+		CertFile:     os.Getenv("API_TLS_CERT"),
+		KeyFile:      os.Getenv("API_TLS_KEY"),
+		ClientCAFile: os.Getenv("API_TLS_CLIENT_CA"),
+	}, dispatch)
+
+	if err != nil {
+		log.Fatalf("Error creating deployment API: %v\n", err)
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			log.Fatalf("Deployment API stopped: %v\n", err)
+		}
+	}()
+}
+
+// runReconciler periodically compares the stored deployments with their containers
+func runReconciler(ctx context.Context, dockerClient deployment.Docker) {
+	interval := time.Minute
+	if value := os.Getenv("RECONCILE_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid RECONCILE_INTERVAL: %v\n", err)
+		}
+		interval = parsed
+	}
+
+	if interval <= 0 {
+		log.Println("Reconciliation disabled")
+		return
+	}
+
+	repair := os.Getenv("RECONCILE_REPAIR") == "true"
+	log.Printf("Reconciling every %v (repair: %v)\n", interval, repair)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		drifts, err := dockerClient.Reconcile(ctx, repair)
+		if err != nil {
+			log.Printf("Error reconciling deployments: %v\n", err)
+			continue
+		}
+
+		if len(drifts) == 0 {
+			continue
+		}
+
+		for _, drift := range drifts {
+			log.Println("Drift detected:", drift)
+		}
+
+		event, err := events.NewEvent(events.DeploymentDrifted, drifts)
+		if err != nil {
+			log.Printf("Error creating %v event: %v\n", events.DeploymentDrifted, err)
+			continue
+		}
+
+		if err := nats.Publish(ctx, events.DeploymentDrifted, event); err != nil {
+			log.Printf("Error publishing %v event: %v\n", events.DeploymentDrifted, err)
+		}
+	}
+}
+
 func main() {
 	//slog.SetLogLoggerLevel(slog.LevelDebug)
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	// Secret values never reach the logs
+	log.SetOutput(redact.Writer(os.Stderr))
 	defer nats.Close()
 
 	ctx := context.Background()
 
-	// Initialise Secret Manager
+	// Initialise Secret Manager, then the docker client resolving secrets through it
 	secretChan := make(chan secrets.SecretManager)
+	dockerChan := make(chan deployment.Docker)
 	go func() {
 		clientSecret := initSecretManager()
 		secretChan <- clientSecret
-	}()
 
-	// Initialise docker client
-	dockerChan := make(chan deployment.Docker)
-	go func() {
-		dockerClient := initDockerClient(ctx)
+		dockerClient := initDockerClient(ctx, clientSecret)
 		dockerChan <- dockerClient
 	}()
 
@@ -123,6 +279,20 @@ func main() {
 	dockerClient := <-dockerChan
 	consumer := <-dockerNats
 
+	// Serve the HTTP deployment API
+	initApi(ctx, dockerClient)
+
+	// Watch for containers drifting from their deployment
+	go runReconciler(ctx, dockerClient)
+
+	// Run the stored cron jobs
+	onRun := func(request deployment.DeploymentRequest, result *deployment.JobResult, err error) {
+		publishCronJobRun(ctx, request, result, err)
+	}
+	if err := dockerClient.StartCronJobs(ctx, onRun); err != nil {
+		log.Printf("Error starting cron jobs: %v\n", err)
+	}
+
 	// Create the consumer to listen to the JetStream
 	consumerInfo, err := consumer.Info(ctx)
 
@@ -162,20 +332,20 @@ func main() {
 
 		switch {
 
-		case msg.Subject() == "Stack.Containers.ImageCreated":
+		case msg.Subject() == events.ImageCreated:
 
 			// Process the new image created
 			go processNewImageCreated(ctx, dockerClient, event)
 
-		case msg.Subject() == "Stack.Secrets.NewSecret2":
-			// Reload the secrets
-			clientSecret.LoadSecrets()
+		case msg.Subject() == events.BuildRequested:
 
-			// Loop over running containers
-			err = dockerClient.RecreateRunningContainers(ctx)
-			if err != nil {
-				log.Printf("Error recreating running containers: %v\n", err)
-			}
+			// Build, push then deploy the image
+			go processBuildRequested(ctx, dockerClient, event)
+
+		case msg.Subject() == events.NewSecret:
+			// Reload the changed secrets and roll the deployments using them, the rollouts
+			// take longer than the ack wait so the message is acked right away
+			go processSecretChanged(ctx, clientSecret, dockerClient, event)
 
 		default:
 			log.Printf("Received a JetStream message: %s\n", string(msg.Data()))
@@ -192,27 +362,299 @@ func main() {
 
 func processNewImageCreated(ctx context.Context, dockerClient deployment.Docker, event cloudevents.Event) {
 	request := deployment.DeploymentRequest{}
-	err := json.Unmarshal(event.Data(), &request)
+	err := events.DecodeData(event, &request)
 
 	if err != nil {
 		log.Printf("Error parsing the event data: %v\n", err)
+		publishOutcome(ctx, events.DeploymentFailed, event, events.DeploymentOutcome{
+			Error: "error parsing the request: " + err.Error(),
+		})
+		return
+	}
+
+	// Nothing is changed for a request with invalid fields
+	if err := dockerClient.Validate(request); err != nil {
+		log.Printf("Rejecting %v: %v\n", request.Metadata.Name, err)
+		rejectRequest(ctx, event, request, err)
+		return
+	}
+
+	switch request.Kind {
+	case "", deployment.KindDeployment:
+		processDeployment(ctx, dockerClient, event, request)
+	case deployment.KindJob:
+		processJob(ctx, dockerClient, event, request)
+	case deployment.KindCronJob:
+		processCronJob(ctx, dockerClient, event, request)
+	}
+}
+
+// rejectRequest publishes the failure of a request that did not pass validation
+func rejectRequest(ctx context.Context, event cloudevents.Event, request deployment.DeploymentRequest, err error) {
+	var fieldErrors []string
+	var invalid *deployment.ValidationError
+	if errors.As(err, &invalid) {
+		for _, fieldError := range invalid.Errors {
+			fieldErrors = append(fieldErrors, fieldError.Error())
+		}
+	}
+
+	if request.Kind == deployment.KindJob {
+		publishOutcome(ctx, events.JobFailed, event, events.JobOutcome{
+			Job:              request.Metadata.Name,
+			Image:            request.Container.Image,
+			Error:            err.Error(),
+			ValidationErrors: fieldErrors,
+		})
+		return
+	}
+
+	publishOutcome(ctx, events.DeploymentFailed, event, events.DeploymentOutcome{
+		Deployment:       request.Metadata.Name,
+		Image:            request.Container.Image,
+		Error:            err.Error(),
+		ValidationErrors: fieldErrors,
+	})
+}
+
+func processSecretChanged(ctx context.Context, clientSecret secrets.SecretManager, dockerClient deployment.Docker, event cloudevents.Event) {
+	// An event without data changes every secret
+	change := events.SecretChange{}
+	if len(event.Data()) > 0 {
+		if err := events.DecodeData(event, &change); err != nil {
+			log.Printf("Error decoding secret change: %v\n", err)
+			return
+		}
+	}
+
+	if err := clientSecret.Reload(change.SecretPath, change.SecretKey); err != nil {
+		log.Printf("Error reloading secrets: %v\n", err)
+		return
 	}
 
+	results, err := dockerClient.RecreateRunningContainers(ctx, change.SecretPath, change.SecretKey, event.ID())
+	if err != nil {
+		log.Printf("Error recreating running containers: %v\n", err)
+		return
+	}
+
+	// Every deployment reports its own outcome
+	for _, rotation := range results {
+		if rotation.Strategy == deployment.SecretReloadNone {
+			continue
+		}
+
+		request := rotation.Revision.Request
+		outcome := events.DeploymentOutcome{
+			Deployment:   request.Metadata.Name,
+			Revision:     rotation.Revision.Number,
+			Image:        request.Container.Image,
+			ImageDigest:  rotation.Revision.ImageDigest,
+			ContainerIDs: rotation.Revision.ContainerIDs,
+		}
+		if result := rotation.Result; result != nil {
+			outcome.Revision = result.Revision
+			outcome.ImageDigest = result.ImageDigest
+			outcome.ContainerIDs = result.ContainerIDs
+			outcome.RolledBack = result.RolledBack
+		}
+
+		switch {
+		case rotation.Err != nil && outcome.RolledBack:
+			outcome.Error = rotation.Err.Error()
+			publishOutcome(ctx, events.DeploymentRolledBack, event, outcome)
+		case rotation.Err != nil:
+			outcome.Error = rotation.Err.Error()
+			publishOutcome(ctx, events.DeploymentFailed, event, outcome)
+		default:
+			log.Printf("Deployment %v reloaded its secrets (%v)\n", outcome.Deployment, rotation.Strategy)
+			publishOutcome(ctx, events.DeploymentSucceeded, event, outcome)
+		}
+	}
+}
+
+func processDeployment(ctx context.Context, dockerClient deployment.Docker, event cloudevents.Event, request deployment.DeploymentRequest) {
+	start := time.Now()
+
 	log.Printf("Received a request to deploy container image: %v\n", request.Container.Image)
 
-	containerId, err := dockerClient.DeployContainer(ctx, request)
+	outcome := events.DeploymentOutcome{
+		Deployment: request.Metadata.Name,
+		Image:      request.Container.Image,
+	}
+	publishOutcome(ctx, events.DeploymentStarted, event, outcome)
+
+	result, err := dockerClient.DeployContainer(ctx, request, event.ID())
 	if err != nil {
 		log.Printf("Error deploying container: %v\n", err)
 	}
 
-	//Save the request object to directory /deployments
-	if containerId == "" {
-		log.Printf("Error deploying container: %v\n", err)
-	} else {
-		fileName := containerId + ".gob"
-		err = utils.SaveToFile(fileName, request)
-		if err != nil {
-			fmt.Println("Error saving object:", err)
-		}
+	outcome.DurationMs = time.Since(start).Milliseconds()
+	if result != nil {
+		outcome.ContainerIDs = result.ContainerIDs
+		outcome.ImageDigest = result.ImageDigest
+		outcome.RolledBack = result.RolledBack
+		outcome.Revision = result.Revision
+	}
+
+	switch {
+	case result != nil && result.RolledBack:
+		outcome.Error = err.Error()
+		publishOutcome(ctx, events.DeploymentRolledBack, event, outcome)
+	case err != nil:
+		outcome.Error = err.Error()
+		publishOutcome(ctx, events.DeploymentFailed, event, outcome)
+	default:
+		publishOutcome(ctx, events.DeploymentSucceeded, event, outcome)
+	}
+
+	if result != nil && result.RolledBack {
+		log.Printf("Deployment rolled back to image %v\n", result.ImageDigest)
+	}
+}
+
+func processJob(ctx context.Context, dockerClient deployment.Docker, event cloudevents.Event, request deployment.DeploymentRequest) {
+	log.Printf("Received a request to run job image: %v\n", request.Container.Image)
+
+	result, err := dockerClient.RunJob(ctx, request, event.ID())
+	if err != nil {
+		log.Printf("Error running job: %v\n", err)
+	}
+
+	eventType, outcome := jobOutcome(request, result, err)
+	publishOutcome(ctx, eventType, event, outcome)
+}
+
+func processCronJob(ctx context.Context, dockerClient deployment.Docker, event cloudevents.Event, request deployment.DeploymentRequest) {
+	log.Printf("Received a request to schedule cron job: %v\n", request.Metadata.Name)
+
+	outcome := events.DeploymentOutcome{
+		Deployment: request.Metadata.Name,
+		Image:      request.Container.Image,
+	}
+
+	if err := dockerClient.ScheduleCronJob(request); err != nil {
+		log.Printf("Error scheduling cron job: %v\n", err)
+		outcome.Error = err.Error()
+		publishOutcome(ctx, events.DeploymentFailed, event, outcome)
+		return
+	}
+
+	publishOutcome(ctx, events.DeploymentSucceeded, event, outcome)
+}
+
+// publishCronJobRun publishes the outcome of a scheduled run of a cron job
+func publishCronJobRun(ctx context.Context, request deployment.DeploymentRequest, result *deployment.JobResult, err error) {
+	if err != nil {
+		log.Printf("Error running cron job %v: %v\n", request.Metadata.Name, err)
+	}
+
+	eventType, outcome := jobOutcome(request, result, err)
+	event, err := events.NewEvent(eventType, outcome)
+	if err != nil {
+		log.Printf("Error creating %v event: %v\n", eventType, err)
+		return
+	}
+
+	if err := nats.Publish(ctx, eventType, event); err != nil {
+		log.Printf("Error publishing %v event: %v\n", eventType, err)
+	}
+}
+
+// jobOutcome returns the Stack.Jobs.* event type and data of a job run
+func jobOutcome(request deployment.DeploymentRequest, result *deployment.JobResult, err error) (string, events.JobOutcome) {
+	outcome := events.JobOutcome{
+		Job:   request.Metadata.Name,
+		Image: request.Container.Image,
+	}
+	if result != nil {
+		outcome.Revision = result.Revision
+		outcome.ImageDigest = result.ImageDigest
+		outcome.ContainerID = result.ContainerID
+		outcome.ExitCode = result.ExitCode
+		outcome.Attempts = result.Attempts
+		outcome.Logs = result.Logs
+		outcome.DurationMs = result.Duration.Milliseconds()
+	}
+
+	if err != nil {
+		outcome.Error = err.Error()
+		return events.JobFailed, outcome
+	}
+	return events.JobCompleted, outcome
+}
+
+func processBuildRequested(ctx context.Context, dockerClient deployment.Docker, event cloudevents.Event) {
+	request := deployment.BuildRequest{}
+	err := events.DecodeData(event, &request)
+
+	if err != nil {
+		log.Printf("Error parsing the event data: %v\n", err)
+		publishOutcome(ctx, events.BuildFailed, event, events.BuildOutcome{
+			Error: "error parsing the request: " + err.Error(),
+		})
+		return
+	}
+
+	log.Printf("Received a request to build container image: %v\n", request.Image)
+
+	err = dockerClient.Build(ctx, request)
+	if err != nil {
+		log.Printf("Error building image: %v\n", err)
+		publishOutcome(ctx, events.BuildFailed, event, buildOutcome(request, err))
+		return
+	}
+
+	if request.Deployment == nil {
+		return
+	}
+
+	// The deployment pulls its image, so it must be in the registry
+	if !request.Push {
+		log.Printf("Image %v was not pushed, skipping its deployment\n", request.Image)
+		publishOutcome(ctx, events.DeploymentFailed, event, events.DeploymentOutcome{
+			Deployment: request.Deployment.Metadata.Name,
+			Image:      request.Image,
+			Error:      "the image was built without push, it cannot be deployed",
+		})
+		return
+	}
+
+	if request.Deployment.Container.Image == "" {
+		request.Deployment.Container.Image = request.Image
+	}
+
+	// Chain the deployment through the stream so it is processed like any other
+	imageCreated, err := events.NewCorrelatedEvent(events.ImageCreated, event, request.Deployment)
+	if err != nil {
+		log.Printf("Error creating %v event: %v\n", events.ImageCreated, err)
+		return
+	}
+
+	if err := nats.Publish(ctx, events.ImageCreated, imageCreated); err != nil {
+		log.Printf("Error publishing %v event: %v\n", events.ImageCreated, err)
+	}
+}
+
+// buildOutcome returns the data of the failure of a build request
+func buildOutcome(request deployment.BuildRequest, err error) events.BuildOutcome {
+	outcome := events.BuildOutcome{Image: request.Image, Error: err.Error()}
+	if request.Deployment != nil {
+		outcome.Deployment = request.Deployment.Metadata.Name
+	}
+	return outcome
+}
+
+// publishOutcome publishes a Stack.Deployments.*, Stack.Jobs.* or Stack.Containers.BuildFailed
+// event correlated with the triggering event
+func publishOutcome(ctx context.Context, eventType string, cause cloudevents.Event, outcome interface{}) {
+	event, err := events.NewCorrelatedEvent(eventType, cause, outcome)
+	if err != nil {
+		log.Printf("Error creating %v event: %v\n", eventType, err)
+		return
+	}
+
+	if err := nats.Publish(ctx, eventType, event); err != nil {
+		log.Printf("Error publishing %v event: %v\n", eventType, err)
 	}
 }
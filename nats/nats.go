@@ -2,6 +2,8 @@ package nats
 
 import (
 	"context"
+	"encoding/json"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"log"
@@ -52,3 +54,20 @@ func CreateDurableConsumer(ctx context.Context, jetstreamName string, consumerNa
 
 	return cons, err
 }
+
+// Publish sends a CloudEvent to the JetStream stream on the given subject
+func Publish(ctx context.Context, subject string, event cloudevents.Event) error {
+	js, err := jetstream.New(NC)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = js.Publish(ctx, subject, data, jetstream.WithMsgID(event.ID()))
+
+	return err
+}
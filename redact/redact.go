@@ -0,0 +1,120 @@
+package redact
+
+import (
+	"cmp"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Mask replaces the secret values
+const Mask = "*****"
+
+// Shorter values are not masked, they would mask unrelated text
+const minLength = 4
+
+var (
+	mu       sync.RWMutex
+	values   = map[string]bool{}
+	replacer *strings.Replacer
+)
+
+// Add registers secret values to mask. Values are kept after a rotation, the old ones
+// are still secrets.
+func Add(secrets ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, secret := range secrets {
+		if len(secret) < minLength || values[secret] {
+			continue
+		}
+		values[secret] = true
+		replacer = nil
+	}
+}
+
+// String masks the registered secret values in s, as is or escaped in a JSON string
+func String(s string) string {
+	return currentReplacer().Replace(s)
+}
+
+// Strings masks the registered secret values in every string of a slice
+func Strings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+
+	masked := make([]string, len(s))
+	for i, value := range s {
+		masked[i] = String(value)
+	}
+	return masked
+}
+
+// Bytes masks the registered secret values in b
+func Bytes(b []byte) []byte {
+	return []byte(String(string(b)))
+}
+
+// Writer masks the registered secret values in everything written to w, a single
+// Write call is expected to hold a whole line as with the log package
+func Writer(w io.Writer) io.Writer {
+	return writer{w}
+}
+
+type writer struct {
+	out io.Writer
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	if _, err := w.out.Write(Bytes(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// currentReplacer returns the replacer of the registered values, built again after Add
+func currentReplacer() *strings.Replacer {
+	mu.RLock()
+	current := replacer
+	mu.RUnlock()
+	if current != nil {
+		return current
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if replacer != nil {
+		return replacer
+	}
+
+	var secrets []string
+	for secret := range values {
+		secrets = append(secrets, secret)
+		if escaped := jsonEscape(secret); escaped != secret {
+			secrets = append(secrets, escaped)
+		}
+	}
+
+	// The longest values first so a value containing another is masked as a whole
+	slices.SortFunc(secrets, func(a, b string) int {
+		return cmp.Or(len(b)-len(a), strings.Compare(a, b))
+	})
+
+	var oldnew []string
+	for _, secret := range secrets {
+		oldnew = append(oldnew, secret, Mask)
+	}
+	replacer = strings.NewReplacer(oldnew...)
+
+	return replacer
+}
+
+// jsonEscape returns a value as it appears inside a JSON string
+func jsonEscape(value string) string {
+	encoded, _ := json.Marshal(value)
+	return string(encoded[1 : len(encoded)-1])
+}
@@ -0,0 +1,42 @@
+package redact
+
+import "testing"
+
+func TestString(t *testing.T) {
+	Add("s3cr3t-token", "s3cr3t-token-long", `pa"ss\word`, "abc", "abcd")
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain value", "token=s3cr3t-token", "token=" + Mask},
+		{"every occurrence", "s3cr3t-token s3cr3t-token", Mask + " " + Mask},
+		{"longest value first", "token=s3cr3t-token-long", "token=" + Mask},
+		{"raw value with quotes", `password pa"ss\word`, "password " + Mask},
+		{"JSON escaped value", `{"password":"pa\"ss\\word"}`, `{"password":"` + Mask + `"}`},
+		{"shorter than 4 characters", "abc", "abc"},
+		{"4 characters", "abcd", Mask},
+		{"no secret", "nothing to hide", "nothing to hide"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := String(test.in); got != test.want {
+				t.Errorf("String(%q) = %q, want %q", test.in, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAddRebuildsReplacer(t *testing.T) {
+	if got := String("late-secret"); got != "late-secret" {
+		t.Fatalf("String masked %q before it was added", got)
+	}
+
+	Add("late-secret")
+
+	if got := String("late-secret"); got != Mask {
+		t.Errorf("String(%q) = %q, want %q", "late-secret", got, Mask)
+	}
+}
@@ -1,6 +1,7 @@
 package secrets
 
 import (
+	"DeploymentManager/cache"
 	infisical "github.com/infisical/go-sdk"
 	"github.com/infisical/go-sdk/packages/models"
 	"log"
@@ -29,6 +30,7 @@ type SecretManager interface {
 	ListFolders(secretPath string) ([]models.Folder, error)
 	ListSecrets(secretPath string) ([]models.Secret, error)
 	LoadSecrets() error
+	Reload(secretPath string, secretKey string) error
 }
 
 // Configs are used to create the deployment client
@@ -45,6 +47,8 @@ type infisicalCmd struct {
 	projectId          string
 	Environment        string
 	EncryptionKey      string
+	// cache holds the secrets listed by LoadSecrets and those retrieved since
+	cache *cache.SecretCache
 }
 
 // NewClient will return a deployment image builder client
@@ -56,7 +60,7 @@ func NewClientSecret(infisicalConfig InfisicalConfig) (SecretManager, error) {
 	_, err := client.Auth().UniversalAuthLogin(infisicalConfig.ClientId, infisicalConfig.ClientSecret)
 
 	if err != nil {
-		log.Panicln("Authentication to Infisical failed: %v", err)
+		log.Panicf("Authentication to Infisical failed: %v\n", err)
 	}
 
 	encryptionKey, _ := GenerateKey()
@@ -67,12 +71,18 @@ func NewClientSecret(infisicalConfig InfisicalConfig) (SecretManager, error) {
 		projectId:          infisicalConfig.ProjectId,
 		Environment:        infisicalConfig.Environment,
 		EncryptionKey:      encryptionKey,
+		cache:              cache.NewSecretCache(),
 	}
 
 	return secretManager, nil
 }
 
+// Get returns a secret from the snapshot of the last LoadSecrets, or from Infisical if it
+// is not in the snapshot
 func (secretManager *infisicalCmd) Get(secretPath string, secretKey string) (models.Secret, error) {
+	if secret, ok := secretManager.cache.Get(secretPath, secretKey); ok {
+		return secret, nil
+	}
 
 	secret, err := secretManager.client.Secrets().Retrieve(infisical.RetrieveSecretOptions{
 		SecretKey:   secretKey,
@@ -80,8 +90,13 @@ func (secretManager *infisicalCmd) Get(secretPath string, secretKey string) (mod
 		ProjectID:   secretManager.projectId,
 		SecretPath:  secretPath,
 	})
+	if err != nil {
+		return secret, err
+	}
 
-	return secret, err
+	secretManager.cache.Set(secretPath, secret)
+
+	return secret, nil
 }
 
 func (secretManager *infisicalCmd) LoadSecrets() error {
@@ -105,9 +120,18 @@ func (secretManager *infisicalCmd) LoadSecrets() error {
 	var wg sync.WaitGroup
 	wg.Add(len(listFoldersRecursive))
 
+	var mu sync.Mutex
+	snapshot := map[string][]models.Secret{}
+
 	for _, folder := range listFoldersRecursive {
 		//log.Println("Loading secrets from folder: ", folder)
-		go loadSecretInEnv(secretManager, folder, &wg)
+		go func(folder string) {
+			secrets := loadFolderSecrets(secretManager, folder, &wg)
+
+			mu.Lock()
+			snapshot[folder] = secrets
+			mu.Unlock()
+		}(folder)
 	}
 	wg.Wait()
 
@@ -115,6 +139,40 @@ func (secretManager *infisicalCmd) LoadSecrets() error {
 		log.Panicf("Error: %v", err)
 	}
 
+	// Deployments resolve their secrets from this snapshot
+	secretManager.cache.Replace(snapshot)
+
+	return nil
+}
+
+// Reload refreshes a single secret of the snapshot, every secret of the folder secretPath
+// when secretKey is empty, or the whole snapshot when both are empty
+func (secretManager *infisicalCmd) Reload(secretPath string, secretKey string) error {
+	switch {
+	case secretPath == "" && secretKey == "":
+		return secretManager.LoadSecrets()
+
+	case secretKey == "":
+		secrets, err := secretManager.ListSecrets(secretPath)
+		if err != nil {
+			return err
+		}
+		secretManager.cache.ReplaceFolder(secretPath, secrets)
+
+	default:
+		secret, err := secretManager.client.Secrets().Retrieve(infisical.RetrieveSecretOptions{
+			SecretKey:   secretKey,
+			Environment: secretManager.Environment,
+			ProjectID:   secretManager.projectId,
+			SecretPath:  secretPath,
+		})
+		if err != nil {
+			return err
+		}
+		secretManager.cache.Set(secretPath, secret)
+	}
+
+	slog.Debug("Secrets reloaded", "path", secretPath, "key", secretKey)
 	return nil
 }
 
@@ -135,15 +193,20 @@ func (secretManager *infisicalCmd) ListSecrets(secretPath string) ([]models.Secr
 		ProjectID:          secretManager.projectId,
 		Environment:        secretManager.Environment,
 		SecretPath:         secretPath,
-		AttachToProcessEnv: true,
+		AttachToProcessEnv: false,
 	})
 
 	return secrets, err
 }
 
-func loadSecretInEnv(secretManager *infisicalCmd, secretPath string, wg *sync.WaitGroup) {
+// loadFolderSecrets lists the secrets of a folder for the snapshot, they are never set in
+// the environment of the manager
+func loadFolderSecrets(secretManager *infisicalCmd, secretPath string, wg *sync.WaitGroup) []models.Secret {
 	defer wg.Done()
-	secrets, _ := secretManager.ListSecrets(secretPath)
+	secrets, err := secretManager.ListSecrets(secretPath)
+	if err != nil {
+		log.Printf("Error loading secrets from %v: %v\n", secretPath, err)
+	}
 
 	// Create an array of all the secret names
 	var secretNames []string
@@ -153,5 +216,7 @@ func loadSecretInEnv(secretManager *infisicalCmd, secretPath string, wg *sync.Wa
 
 	//log.Printf("--> Secrets loaded from %v: %v secrets: %v", secretPath, len(secrets), secretNames)
 	//log.Printf("--> Secrets loaded from %v: %v", secretPath, len(secrets))
-	slog.Debug("Secrets loaded from %v: %v secrets: %v", secretPath, len(secrets), secretNames)
+	slog.Debug("Secrets loaded", "path", secretPath, "count", len(secrets), "names", secretNames)
+
+	return secrets
 }
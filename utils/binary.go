@@ -5,23 +5,8 @@ import (
 	"os"
 )
 
-// SaveToFile Save an object to a file using encoding/gob
-func SaveToFile(filename string, obj interface{}) error {
-	file, err := os.Create("/data/" + filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	encoder := gob.NewEncoder(file)
-	err = encoder.Encode(obj)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-// ReadFromFile Read an object from a file using encoding/gob
+// ReadFromFile Read an object from a file using encoding/gob. Requests are no longer saved
+// this way, the legacy records are only read, they may hold secret values.
 func ReadFromFile(filename string, obj interface{}) error {
 	file, err := os.Open("/data/" + filename)
 	if err != nil {